@@ -6,65 +6,197 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 
 	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/client"
 )
 
 var ErrContractFulfilled = errors.New("contract fulfilled")
 
-var defaultActivityInterval = 10 * time.Second
+// ErrShipIdle is returned by a ship's activity when it can't make progress until something else
+// changes. The ship isn't scheduled again until it is woken, e.g. by a change to its assignment.
+var ErrShipIdle = errors.New("ship has nothing to do")
 
+// transientErrorRetryInterval is how long a ship waits before trying again after a transient API
+// error.
+var transientErrorRetryInterval = 10 * time.Second
+
+// turnResult reports a ship's turn which failed.
+type turnResult struct {
+	shipID string
+	err    error
+}
+
+// runActivityLoop runs the activity of each ship assigned to an active contract. A scheduler wakes
+// each ship when it is next ready, and each turn runs in its own goroutine so that one ship's slow
+// action doesn't hold up the others. It runs until interrupted, until ctx is done or until a turn
+// fails; in-flight turns are finished before it returns.
 func runActivityLoop(ctx context.Context, app *App) error {
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 	defer signal.Stop(interrupt)
-	timer := time.NewTimer(0)
+
+	sched := newScheduler(app.clock)
+	defer sched.timer.Stop()
+	app.setScheduler(sched)
+	defer app.setScheduler(nil)
+	for _, shipID := range app.workableShips() {
+		sched.wake(shipID)
+	}
+
+	stop := make(chan struct{})
+	results := make(chan turnResult)
+	var wg sync.WaitGroup
+	shutdown := func() {
+		close(stop)
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+		for range results {
+		}
+	}
 	for {
 		select {
 		case <-interrupt:
-			timer.Stop()
+			app.log.Info("Stopping activity; waiting for ships to finish their current actions")
+			shutdown()
 			return nil
-		case <-timer.C:
-			readyTime, err := app.runActivity(ctx)
-			if err != nil && !errors.Is(err, ErrContractFulfilled) {
-				return err
+		case <-ctx.Done():
+			shutdown()
+			return ctx.Err()
+		case r := <-results:
+			if ctx.Err() != nil {
+				// Cancelled mid-request; the ctx.Done case returns.
+				continue
 			}
-			if readyTime.IsZero() {
-				fmt.Printf("Waiting %s for next round\n", defaultActivityInterval)
-				timer.Reset(defaultActivityInterval)
-			} else {
-				fmt.Printf("Waiting until %s for next round\n", readyTime)
-				timer.Reset(readyTime.Sub(time.Now()))
+			activityErrors.Inc("fatal")
+			shutdown()
+			return fmt.Errorf("ship %s: %w", r.shipID, r.err)
+		case <-sched.timer.C():
+			for _, shipID := range sched.due() {
+				wg.Add(1)
+				go func(shipID string) {
+					defer wg.Done()
+					if err := app.shipTurn(ctx, sched, shipID); err != nil {
+						select {
+						case results <- turnResult{shipID: shipID, err: err}:
+						case <-stop:
+						}
+					}
+				}(shipID)
 			}
 		}
 	}
 }
 
-func (a *App) runActivity(ctx context.Context) (time.Time, error) {
-	nextReadyTime := time.Time{}
-	hasFulfilledContract := false
-	for _, c := range a.activeContracts {
-		ac := a.augmentContract(c)
-		for _, s := range ac.Ships {
-			readyTime, err := a.shipActivityWrapper(ctx, a.augmentShip(s.Symbol))
-			if err != nil {
-				if errors.Is(err, ErrContractFulfilled) {
-					hasFulfilledContract = true
-					break
-				} else {
-					return time.Time{}, err
-				}
-			}
-			if !readyTime.IsZero() && (nextReadyTime.IsZero() || readyTime.Before(nextReadyTime)) {
-				nextReadyTime = readyTime
-			}
+// workableShips returns the ships which have something to do; see hasWork.
+func (a *App) workableShips() []string {
+	a.mu.RLock()
+	var shipIDs []string
+	for shipID := range a.ships {
+		shipIDs = append(shipIDs, shipID)
+	}
+	a.mu.RUnlock()
+	var ships []string
+	for _, shipID := range shipIDs {
+		if a.hasWork(a.augmentShip(shipID)) {
+			ships = append(ships, shipID)
 		}
 	}
-	if hasFulfilledContract {
-		return nextReadyTime, ErrContractFulfilled
+	return ships
+}
+
+func (a *App) setScheduler(sched *scheduler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sched = sched
+}
+
+// wakeShips lets ships act as soon as possible if the activity loop is running, e.g. because their
+// assignments or contract changed.
+func (a *App) wakeShips(shipIDs ...string) {
+	a.mu.RLock()
+	sched := a.sched
+	a.mu.RUnlock()
+	if sched == nil {
+		return
+	}
+	for _, shipID := range shipIDs {
+		sched.wake(shipID)
 	}
-	return nextReadyTime, nil
+}
+
+// Idle reports whether the activity loop is running and every ship is waiting to be woken, rather
+// than acting. Whatever drives a fake clock can use it to tell when to move the clock on.
+func (a *App) Idle() bool {
+	a.mu.RLock()
+	sched := a.sched
+	a.mu.RUnlock()
+	return sched != nil && sched.idle()
+}
+
+// shipTurn runs the next action of a ship's activity, then returns it to the scheduler for when it
+// is next ready. Ships with nothing to do, e.g. because their contract was fulfilled or abandoned,
+// are dropped from the scheduler.
+func (a *App) shipTurn(ctx context.Context, sched *scheduler, shipID string) error {
+	as := a.augmentShip(shipID)
+	if !a.hasWork(as) {
+		sched.drop(shipID)
+		return nil
+	}
+	readyTime, err := a.shipActivityWrapper(ctx, as)
+	a.updateMetrics()
+	var deadlineErr *ContractDeadlineError
+	var transientErr *client.TransientError
+	switch {
+	case errors.Is(err, ErrContractFulfilled):
+		sched.drop(shipID)
+		return nil
+	case errors.Is(err, ErrShipIdle):
+		as.log().Warn("Ship is idle until woken", "reason", err)
+		sched.drop(shipID)
+		return nil
+	case errors.As(err, &deadlineErr):
+		sched.drop(shipID)
+		return a.abandonContract(as.contractID, deadlineErr)
+	case errors.As(err, &transientErr):
+		// The server is having a bad time; rather than giving up entirely, try again later. The failed
+		// request may still have been processed, so refresh our view of the ship first.
+		as.log().Warn("Transient API error; retrying later", "error", err, "duration", transientErrorRetryInterval)
+		activityErrors.Inc("transient")
+		if err := as.Refresh(ctx); err != nil {
+			as.log().Warn("Could not refresh ship", "error", err)
+		}
+		readyTime = a.clock.Now().Add(transientErrorRetryInterval)
+	case err != nil:
+		// Another ship may have fulfilled or abandoned the contract while this one was working on it;
+		// wait for that to finish before checking.
+		a.contractsMu.Lock()
+		_, ok := a.getContract(as.contractID)
+		a.contractsMu.Unlock()
+		if !ok {
+			sched.drop(shipID)
+			return nil
+		}
+		return err
+	}
+	if !readyTime.IsZero() {
+		a.log.Debug("Waiting until ship is ready", "ship", shipID, "until", readyTime)
+	}
+	sched.done(shipID, readyTime)
+	return nil
+}
+
+// RunActivity loads the agent's data and runs the activity loop until interrupted or until ctx is
+// done.
+func (a *App) RunActivity(ctx context.Context) error {
+	if err := a.loadData(ctx); err != nil {
+		return err
+	}
+	return runActivityLoop(ctx, a)
 }
 
 func (a *App) shipActivityWrapper(ctx context.Context, as *AugmentedShip) (time.Time, error) {
@@ -79,17 +211,20 @@ func (a *App) shipActivityWrapper(ctx context.Context, as *AugmentedShip) (time.
 }
 
 func (a *App) shipActivity(ctx context.Context, as *AugmentedShip) (time.Time, error) {
-	if as.Contract().Type != "PROCUREMENT" {
-		return time.Time{}, fmt.Errorf("Unsupported contract type %s", as.Contract().Type)
-	}
-	switch as.Ship().Registration.Role {
-	case api.SHIPROLE_EXCAVATOR:
-		return a.procurementActivity(ctx, as)
-	case api.SHIPROLE_COMMAND:
-		return a.procurementActivity(ctx, as)
-	default:
-		return time.Time{}, fmt.Errorf("Unsupported ship role %s", as.Ship().Registration.Role)
+	b := a.shipBehavior(as)
+	if b == nil {
+		assignment, _ := assignmentType(as)
+		if !a.handlesAssignment(assignment) {
+			// Nothing automates this contract type yet, so leave the ship be rather than stopping
+			// every other ship.
+			return time.Time{}, fmt.Errorf("%w: no behavior handles %s contracts", ErrShipIdle, assignment)
+		}
+		if assignment == NoAssignment {
+			assignment = "no"
+		}
+		return time.Time{}, fmt.Errorf("No behavior for %s ships with %s assignment", as.Ship().Registration.Role, assignment)
 	}
+	return b.Act(ctx, as)
 }
 
 func (a *App) procurementActivity(ctx context.Context, as *AugmentedShip) (time.Time, error) {
@@ -104,7 +239,7 @@ func (a *App) procurementActivity(ctx context.Context, as *AugmentedShip) (time.
 	}
 
 	if waypointTraits["MARKETPLACE"] && as.Ship().Fuel.Current < as.Ship().Fuel.Capacity {
-		fmt.Printf("%s (%s) attempting to refuel\n", as.Ship().Registration.Name, as.Ship().Registration.Role)
+		as.log().Info("Refuelling", "action", "refuel")
 		if err := as.TryRefuel(ctx); err != nil {
 			return time.Time{}, err
 		}
@@ -173,7 +308,7 @@ func (a *App) procurementActivity(ctx context.Context, as *AugmentedShip) (time.
 	}
 
 	for symbol, units := range cargoToDeliver {
-		fmt.Printf("%s (%s) delivering %d units of %s at %s\n", as.Ship().Registration.Name, as.Ship().Registration.Role, units, symbol, as.Ship().Nav.WaypointSymbol)
+		as.log().Info("Delivering goods", "action", "deliver", "trade_symbol", symbol, "units", units)
 		if err := as.DeliverGoods(ctx, symbol, units); err != nil {
 			return time.Time{}, err
 		}
@@ -185,43 +320,63 @@ func (a *App) procurementActivity(ctx context.Context, as *AugmentedShip) (time.
 			if symbol == "ANTIMATTER" {
 				continue
 			}
-			fmt.Printf("%s (%s) selling %d unneeded units of %s at %s\n", as.Ship().Registration.Name, as.Ship().Registration.Role, units, symbol, as.Ship().Nav.WaypointSymbol)
+			as.log().Info("Selling unneeded cargo", "action", "sell", "trade_symbol", symbol, "units", units)
 			if err := as.SellCargo(ctx, symbol, units); err != nil {
+				var notSoldErr *TradeNotSoldError
+				if errors.As(err, &notSoldErr) {
+					as.log().Warn("Market does not buy cargo", "action", "sell", "trade_symbol", symbol, "units", units)
+					continue
+				}
 				return time.Time{}, err
 			}
 		}
 	}
 
+	// Goods which the contract doesn't need. Those which no marketplace buys are dumped, since they
+	// would otherwise fill the hold for good.
+	contractGoods := map[string]bool{}
+	for _, d := range as.Contract().Terms.Deliver {
+		contractGoods[d.TradeSymbol] = true
+	}
+	surplus := func(symbol string) bool {
+		return symbol != "ANTIMATTER" && !contractGoods[symbol]
+	}
+	if err := a.jettisonUnsellable(ctx, as, surplus); err != nil {
+		return time.Time{}, err
+	}
+
 	if len(otherDeliveryLocations) == 0 && len(materialsToObtain) == 0 {
 		// We don't need to deliver anything elsewhere, and we don't need to obtain more materials, so
 		// the contract is complete. The ship then has no assigned contract, and thus has nothing to do.
 		// Note: the fulfillContract method prints to stdout
-		a.fulfillContract(ctx, as.contractID)
+		if err := a.fulfillContract(ctx, as.contractID); err != nil {
+			return time.Time{}, err
+		}
 		return time.Time{}, ErrContractFulfilled
 	}
 
 	// If this ship can survey, and we don't have high quality surveys for all materials we want, do a
 	// survey. Note that surveying and extraction use the same cooldown (i.e. we can't survey and then
 	// immediately extract resources)
-	if as.HasMount("MOUNT_SURVEYOR") {
+	if waypointTraits["MINERAL_DEPOSITS"] && as.HasMount("MOUNT_SURVEYOR") {
 		shouldSurvey := false
 		for material := range materialsToObtain {
 			survey := a.getSurvey(as.Ship().Nav.WaypointSymbol, material)
-			if survey == nil || mineralFractions(*survey)[material] < 0.2 {
+			if survey == nil || mineralFractions(*survey)[material] < a.strategy.SurveyThreshold {
 				shouldSurvey = true
 			}
 		}
 		if shouldSurvey {
-			fmt.Printf("%s (%s) surveying at %s\n", as.Ship().Registration.Name, as.Ship().Registration.Role, as.Ship().Nav.WaypointSymbol)
+			as.log().Info("Surveying", "action", "survey")
 			return as.Survey(ctx)
 		}
 	}
 
 	// If currently at a mining location, more materials are required, and there is space in the cargo
 	// hold, do some mining
-	if waypointTraits["MINERAL_DEPOSITS"] && len(materialsToObtain) > 0 && float64(as.Ship().Cargo.Units)/float64(as.Ship().Cargo.Capacity) < 0.85 {
+	if waypointTraits["MINERAL_DEPOSITS"] && len(materialsToObtain) > 0 && float64(as.Ship().Cargo.Units)/float64(as.Ship().Cargo.Capacity) < a.strategy.ExtractCargoThreshold {
 		for material := range materialsToObtain {
-			fmt.Printf("%s (%s) extracting %s at %s\n", as.Ship().Registration.Name, as.Ship().Registration.Role, material, as.Ship().Nav.WaypointSymbol)
+			as.log().Info("Extracting", "action", "extract", "target", material)
 			return as.Extract(ctx, material)
 		}
 	}
@@ -229,10 +384,23 @@ func (a *App) procurementActivity(ctx context.Context, as *AugmentedShip) (time.
 	// If we have cargo to deliver elsewhere, go there.
 	// TODO: Choose location in a clever way instead of randomly/arbitrarily
 	for location := range otherDeliveryLocations {
-		fmt.Printf("%s (%s) travelling to %s to deliver goods\n", as.Ship().Registration.Name, as.Ship().Registration.Role, location)
+		as.log().Info("Travelling to deliver goods", "action", "navigate", "destination", location)
 		return as.TravelTo(ctx, location)
 	}
 
+	// If the hold is too full to extract, make room by selling what the contract doesn't need.
+	if float64(as.Ship().Cargo.Units)/float64(as.Ship().Cargo.Capacity) >= a.strategy.ExtractCargoThreshold {
+		market, err := a.bestMarket(ctx, as, surplus)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if market == "" || market == as.Ship().Nav.WaypointSymbol {
+			return time.Time{}, fmt.Errorf("%w: cargo hold too full to extract, and no known marketplace buys the rest", ErrShipIdle)
+		}
+		as.log().Info("Travelling to sell unneeded cargo", "action", "navigate", "destination", market)
+		return as.TravelTo(ctx, market)
+	}
+
 	// Otherwise, we need to find somewhere to obtain minerals
 	wps, err := a.getWaypoints(ctx, as.Ship().Nav.SystemSymbol)
 	if err != nil {
@@ -241,7 +409,11 @@ func (a *App) procurementActivity(ctx context.Context, as *AugmentedShip) (time.
 	for _, wp := range wps {
 		for _, t := range wp.Traits {
 			if t.Symbol == "MINERAL_DEPOSITS" {
-				fmt.Printf("%s (%s) travelling to %s to extract resources\n", as.Ship().Registration.Name, as.Ship().Registration.Role, as.Ship().Nav.WaypointSymbol)
+				if wp.Symbol == as.Ship().Nav.WaypointSymbol {
+					// Already here, but nothing more can be extracted, delivered or sold.
+					return time.Time{}, fmt.Errorf("%w: cargo hold too full to extract", ErrShipIdle)
+				}
+				as.log().Info("Travelling to extract resources", "action", "navigate", "destination", wp.Symbol)
 				return as.TravelTo(ctx, wp.Symbol)
 			}
 		}
@@ -252,7 +424,7 @@ func (a *App) procurementActivity(ctx context.Context, as *AugmentedShip) (time.
 
 func (a *App) checkShipTransit(ctx context.Context, as *AugmentedShip) (time.Time, error) {
 	if as.Ship().Nav.Status == api.SHIPNAVSTATUS_IN_TRANSIT {
-		if as.Ship().Nav.Route.Arrival.Before(time.Now()) {
+		if !a.clock.Now().Before(as.Ship().Nav.Route.Arrival) {
 			if err := as.Refresh(ctx); err != nil {
 				return time.Time{}, err
 			}
@@ -260,7 +432,7 @@ func (a *App) checkShipTransit(ctx context.Context, as *AugmentedShip) (time.Tim
 				return time.Time{}, errors.New("Ship still in transit after arrival time")
 			}
 		} else {
-			fmt.Printf("%s (%s) still in transit to %s, arriving %s\n", as.Ship().Registration.Name, as.Ship().Registration.Role, as.Ship().Nav.Route.Destination.Symbol, as.Ship().Nav.Route.Arrival)
+			as.log().Debug("Still in transit", "destination", as.Ship().Nav.Route.Destination.Symbol, "arrival", as.Ship().Nav.Route.Arrival)
 			return as.Ship().Nav.Route.Arrival, nil
 		}
 	}
@@ -268,6 +440,9 @@ func (a *App) checkShipTransit(ctx context.Context, as *AugmentedShip) (time.Tim
 }
 
 func (a *App) getCurrentWaypointTraits(ctx context.Context, as *AugmentedShip) (map[string]bool, error) {
+	if err := a.observeWaypoint(ctx, as); err != nil {
+		return nil, err
+	}
 	wp, err := a.getWaypoint(ctx, as.Ship().Nav.SystemSymbol, as.Ship().Nav.WaypointSymbol)
 	if err != nil {
 		return nil, err
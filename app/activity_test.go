@@ -0,0 +1,77 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/fakeserver"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+func TestCheckShipTransit(t *testing.T) {
+	ctx, a, _, clk, reg := testApp(t)
+	as := a.augmentShip(reg.Ship.Symbol)
+
+	if readyTime, err := a.checkShipTransit(ctx, as); err != nil || !readyTime.IsZero() {
+		t.Fatalf("docked ship: ready at %v, error %v; want zero time", readyTime, err)
+	}
+	arrival, err := as.TravelTo(ctx, fakeserver.AsteroidField)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !arrival.After(clk.Now()) {
+		t.Fatalf("arrival %v is not after now %v", arrival, clk.Now())
+	}
+
+	clk.Set(arrival.Add(-1))
+	if readyTime, err := a.checkShipTransit(ctx, as); err != nil || !readyTime.Equal(arrival) {
+		t.Errorf("ship in transit: ready at %v, error %v; want %v", readyTime, err, arrival)
+	}
+	if status := as.Ship().Nav.Status; status != api.SHIPNAVSTATUS_IN_TRANSIT {
+		t.Errorf("status before arrival = %s, want IN_TRANSIT", status)
+	}
+
+	clk.Set(arrival)
+	if readyTime, err := a.checkShipTransit(ctx, as); err != nil || !readyTime.IsZero() {
+		t.Errorf("arrived ship: ready at %v, error %v; want zero time", readyTime, err)
+	}
+	if nav := as.Ship().Nav; nav.Status != api.SHIPNAVSTATUS_IN_ORBIT || nav.WaypointSymbol != fakeserver.AsteroidField {
+		t.Errorf("after arrival ship is %s at %s, want IN_ORBIT at %s", nav.Status, nav.WaypointSymbol, fakeserver.AsteroidField)
+	}
+}
+
+// TestShipActivityWithoutBehavior checks that only ships on contract types which nothing automates
+// are left idle; a ship whose role can't work a handled contract type is an error.
+func TestShipActivityWithoutBehavior(t *testing.T) {
+	ctx, a, _, _, reg := testApp(t)
+	cID := reg.Contract.Id
+	shipID := reg.Ship.Symbol
+	if err := a.state.Update(func(ms state.MutableState) error {
+		ms.AssignShip(cID, shipID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.loadContracts(ctx); err != nil {
+		t.Fatal(err)
+	}
+	as := a.augmentShip(shipID)
+
+	// A contract type the game may add, which no behavior handles.
+	c, _ := a.getContract(cID)
+	c.Type = "SALVAGE"
+	a.updateContract(c)
+	if _, err := a.shipActivity(ctx, as); !errors.Is(err, ErrShipIdle) {
+		t.Errorf("ship on salvage contract: error %v, want ErrShipIdle", err)
+	}
+
+	c.Type = "PROCUREMENT"
+	a.updateContract(c)
+	a.updateShip(shipID, func(ship *api.Ship) {
+		ship.Registration.Role = api.SHIPROLE_SURVEYOR
+	})
+	if _, err := a.shipActivity(ctx, as); err == nil || errors.Is(err, ErrShipIdle) {
+		t.Errorf("surveyor on procurement contract: error %v, want a fatal error", err)
+	}
+}
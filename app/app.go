@@ -2,22 +2,97 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/client"
+	"fivebit.co.uk/spacetraders/clock"
+	"fivebit.co.uk/spacetraders/config"
+	"fivebit.co.uk/spacetraders/journal"
+	"fivebit.co.uk/spacetraders/logging"
+	"fivebit.co.uk/spacetraders/metrics"
 	"fivebit.co.uk/spacetraders/prompt"
 	"fivebit.co.uk/spacetraders/state"
 )
 
 type App struct {
-	state           state.State
-	client          *api.APIClient
+	state  state.State
+	client *api.APIClient
+	// mu guards agent, ships, activeContracts, waypoints, surveys, marketPrices, marketGoods,
+	// shipReadyTimes and sched, which the activity loop's ship turns share.
+	mu sync.RWMutex
+	// contractsMu serialises fulfilling and abandoning contracts, so that only one of the ships
+	// working on a contract does so.
+	contractsMu     sync.Mutex
 	agent           api.Agent
 	ships           map[string]api.Ship
 	activeContracts map[string]api.Contract
 	waypoints       map[string][]api.Waypoint
+	cache           *state.Cache
+	journal         *journal.Journal
 	surveys         map[string]map[string]*api.Survey
-	shipReadyTimes   map[string]time.Time
+	// marketPrices holds the last price per unit each marketplace paid for each good, or 0 if it
+	// wouldn't buy it.
+	marketPrices map[string]map[string]int32
+	// marketGoods holds the goods each marketplace sells, from its last fetched listing.
+	marketGoods    map[string]map[string]bool
+	shipReadyTimes map[string]time.Time
+	// sched is the activity loop's scheduler while it is running.
+	sched         *scheduler
+	strategy      Strategy
+	behaviors     behaviorRegistry
+	clock         clock.Clock
+	log           *slog.Logger
+	autoReconcile bool
+	profile       string
+}
+
+// New creates an App using the given client and state, e.g. a client for a fake server and an
+// in-memory state.
+func New(client *api.APIClient, s state.State) *App {
+	a := &App{
+		state:     s,
+		client:    client,
+		cache:     state.NewInMemoryCache(state.CacheOptions{}),
+		waypoints: map[string][]api.Waypoint{},
+		strategy:  DefaultStrategy(),
+		clock:     clock.Real(),
+		log:       slog.Default(),
+	}
+	a.RegisterBehavior(procurementBehavior{a}, "PROCUREMENT", api.SHIPROLE_EXCAVATOR, api.SHIPROLE_COMMAND)
+	for _, contractType := range []string{"TRANSPORT", "SHUTTLE"} {
+		a.RegisterBehavior(transportBehavior{a}, contractType, api.SHIPROLE_HAULER, api.SHIPROLE_TRANSPORT, api.SHIPROLE_COMMAND, api.SHIPROLE_EXCAVATOR)
+	}
+	a.RegisterBehavior(&mineAndSellBehavior{app: a, config: DefaultMineAndSellConfig()}, NoAssignment, api.SHIPROLE_EXCAVATOR, api.SHIPROLE_COMMAND)
+	return a
+}
+
+// SetCache replaces the in-memory cache of systems and waypoints, e.g. with one saved on disk.
+func (a *App) SetCache(c *state.Cache) {
+	a.cache = c
+}
+
+// SetClock replaces the real clock used to schedule activity and check expiry times, e.g. with a
+// fake clock to run the activity loop in simulated time.
+func (a *App) SetClock(c clock.Clock) {
+	a.clock = c
+}
+
+// SetLogger replaces the default logger used by the activity loop.
+func (a *App) SetLogger(l *slog.Logger) {
+	a.log = l
+}
+
+// SetAutoReconcile controls whether corrections to the saved assignments found on startup are
+// applied without asking for confirmation.
+func (a *App) SetAutoReconcile(auto bool) {
+	a.autoReconcile = auto
 }
 
 func (a *App) MenuItem(ctx context.Context, label string, fn func(ctx context.Context, app *App) error) prompt.MenuItem {
@@ -32,6 +107,16 @@ func (a *App) MenuItem(ctx context.Context, label string, fn func(ctx context.Co
 
 func (a *App) Run(ctx context.Context) error {
 	if err := a.loadData(ctx); err != nil {
+		var tokenErr *TokenError
+		var tokenResetErr *TokenResetError
+		if errors.As(err, &tokenResetErr) {
+			return fmt.Errorf("the server has been reset since this agent was registered: %w", err)
+		} else if errors.As(err, &tokenErr) {
+			return fmt.Errorf("the saved agent token was rejected: %w", err)
+		}
+		return err
+	}
+	if err := a.reconcile(); err != nil {
 		return err
 	}
 	return prompt.Menu("Choose action", []prompt.MenuItem{
@@ -41,11 +126,19 @@ func (a *App) Run(ctx context.Context) error {
 		a.MenuItem(ctx, "View waypoint", viewWaypoint),
 		a.MenuItem(ctx, "View contracts", viewContracts),
 		a.MenuItem(ctx, "View fleet", viewFleet),
+		a.MenuItem(ctx, "View journal", viewJournal),
+		a.MenuItem(ctx, "Configure behaviors", configureBehaviors),
 		a.MenuItem(ctx, "Buy ship", buyShip),
+		a.MenuItem(ctx, "Profiles", manageProfiles),
+		a.MenuItem(ctx, "Clear cache", clearCache),
+		a.MenuItem(ctx, "Restore backup", restoreBackup),
 		{
 			Label: "Reload data",
 			Fn: func() error {
-				return a.loadData(ctx)
+				if err := a.loadData(ctx); err != nil {
+					return err
+				}
+				return a.reconcile()
 			},
 			Loop: true,
 		},
@@ -53,16 +146,149 @@ func (a *App) Run(ctx context.Context) error {
 	})
 }
 
-func Run(ctx context.Context) error {
-	client := api.NewAPIClient(api.NewConfiguration())
-	s, err := state.Get(ctx, client)
+type Options struct {
+	// RecordPath, if set, is a file to record all API requests and responses to.
+	RecordPath string
+	// ReplayPath, if set, is a file of previously recorded API responses to serve instead of
+	// contacting the server.
+	ReplayPath string
+	// ReplayStrict causes requests which were not recorded in ReplayPath to fail.
+	ReplayStrict bool
+	// ReadOnly opens the state without locking it, e.g. to view data while another instance is
+	// running. Anything which would change the state fails.
+	ReadOnly bool
+	// ConfigPath is the config file to read; empty means the default location next to the state
+	// file.
+	ConfigPath string
+	// MetricsAddr, if set, is the address to serve Prometheus metrics on at /metrics.
+	MetricsAddr string
+	// AutoReconcile applies corrections to the saved assignments on startup without asking.
+	AutoReconcile bool
+	// AutoReregister archives the state and registers the agent again after a server reset without
+	// asking, e.g. when running unattended.
+	AutoReregister bool
+	// ExportPath, if set, is a file to export the profile to, instead of running.
+	ExportPath string
+	// ImportPath, if set, is a file previously written with ExportPath to import into the profile,
+	// instead of running.
+	ImportPath string
+	// Profile is the profile to load; empty means the one named by $SPACETRADERS_PROFILE, or the
+	// default profile.
+	Profile string
+	Logging logging.Options
+}
+
+func Run(ctx context.Context, opts Options) error {
+	logger, logCloser, err := logging.New(opts.Logging)
+	if err != nil {
+		return err
+	}
+	defer logCloser.Close()
+	if opts.MetricsAddr != "" {
+		if err := metrics.Serve(opts.MetricsAddr); err != nil {
+			return err
+		}
+	}
+	cfg, err := config.Load(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+	profile := opts.Profile
+	if profile == "" {
+		profile = os.Getenv(state.ProfileEnv)
+	}
+	if profile == "" {
+		profile = state.DefaultProfile
+	}
+	if opts.ExportPath != "" {
+		return exportProfile(profile, opts.ExportPath)
+	}
+	if opts.ImportPath != "" {
+		return importProfile(profile, opts.ImportPath)
+	}
+	clientOpts := []client.Option{client.WithConfig(cfg)}
+	if opts.RecordPath != "" {
+		clientOpts = append(clientOpts, client.WithRecording(opts.RecordPath))
+	}
+	if opts.ReplayPath != "" {
+		clientOpts = append(clientOpts, client.WithReplay(opts.ReplayPath, opts.ReplayStrict))
+	}
+	client, err := client.New(clientOpts...)
+	if err != nil {
+		return err
+	}
+	previous := ""
+	for {
+		err := runProfile(ctx, client, logger, cfg, profile, opts)
+		var switchErr *switchProfileError
+		if !errors.As(err, &switchErr) {
+			if err != nil && previous != "" {
+				// The profile we switched to could not be loaded, e.g. because registration was
+				// cancelled; go back to the one which was working.
+				fmt.Printf("Could not load profile %s: %v\n", profile, err)
+				profile, previous = previous, ""
+				continue
+			}
+			return err
+		}
+		profile, previous = switchErr.profile, profile
+	}
+}
+
+// runProfile loads a profile's state and runs the main menu with it.
+func runProfile(ctx context.Context, client *api.APIClient, logger *slog.Logger, cfg config.Config, profile string, opts Options) error {
+	secrets, err := tokenProvider(cfg.Token, profile)
+	if err != nil {
+		return err
+	}
+	s, err := state.Get(ctx, client, profile, state.Options{
+		ReadOnly: opts.ReadOnly,
+		Secrets:  secrets,
+		Backups: state.BackupOptions{
+			Keep:     cfg.Backups.Keep,
+			Interval: time.Duration(cfg.Backups.Interval),
+		},
+	})
 	if err != nil {
 		return err
 	}
-	app := &App{
-		state:  s,
-		client: client,
+	defer func() {
+		s.Close()
+	}()
+	if s, err = checkServerReset(ctx, client, s, opts.AutoReregister); err != nil {
+		return err
+	}
+	fmt.Printf("Using profile %s\n", profile)
+	for {
+		cache, err := state.OpenCache(profile, opts.ReadOnly, state.CacheOptions{
+			SystemTTL:   time.Duration(cfg.Cache.SystemTTL),
+			WaypointTTL: time.Duration(cfg.Cache.WaypointTTL),
+		})
+		if err != nil {
+			return err
+		}
+		dir, err := state.ProfileDir(profile)
+		if err != nil {
+			return err
+		}
+		j, err := journal.Open(filepath.Join(dir, "journal.jsonl"), opts.ReadOnly)
+		if err != nil {
+			return err
+		}
+		app := New(client, s)
+		app.SetLogger(logger.With("profile", profile))
+		app.SetAutoReconcile(opts.AutoReconcile)
+		app.SetProfile(profile)
+		app.SetCache(cache)
+		app.SetJournal(j)
+		err = app.Run(context.WithValue(ctx, api.ContextAccessToken, s.GetToken()))
+		j.Close()
+		var tokenResetErr *TokenResetError
+		if !errors.As(err, &tokenResetErr) {
+			return err
+		}
+		if s, err = reregister(ctx, client, s, opts.AutoReregister, err.Error()); err != nil {
+			return err
+		}
 	}
-	ctx = context.WithValue(ctx, api.ContextAccessToken, s.GetToken())
-	return app.Run(ctx)
 }
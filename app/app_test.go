@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/clock"
+	"fivebit.co.uk/spacetraders/fakeserver"
+	"fivebit.co.uk/spacetraders/logging"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+var testStart = time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+// testApp registers an agent with a fake server running on a fake clock, and returns an App for it
+// with its data loaded, along with the registration response.
+func testApp(t *testing.T) (context.Context, *App, *fakeserver.Server, *clock.Fake, api.Register201ResponseData) {
+	t.Helper()
+	return testAppInUniverse(t, fakeserver.DefaultUniverse())
+}
+
+// testAppInUniverse is testApp with a fake server serving the given universe.
+func testAppInUniverse(t *testing.T, u fakeserver.Universe) (context.Context, *App, *fakeserver.Server, *clock.Fake, api.Register201ResponseData) {
+	t.Helper()
+	clk := clock.NewFake(testStart)
+	srv := fakeserver.NewWithUniverse(u, clk.Now).Start()
+	t.Cleanup(srv.Close)
+	client := srv.Client()
+
+	ctx := context.Background()
+	reg, _, err := client.DefaultApi.Register(ctx).RegisterRequest(api.RegisterRequest{Symbol: "TESTER", Faction: "COSMIC"}).Execute()
+	if err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+	ctx = context.WithValue(ctx, api.ContextAccessToken, reg.Data.Token)
+
+	a := New(client, state.NewInMemory("TESTER", "COSMIC", reg.Data.Token))
+	a.SetClock(clk)
+	a.SetLogger(logging.Discard())
+	if err := a.loadData(ctx); err != nil {
+		t.Fatalf("loading data: %v", err)
+	}
+	return ctx, a, srv, clk, reg.Data
+}
+
+// TestContract runs the starting contract from acceptance to fulfilment with the command ship,
+// moving the clock on whenever the ship has to wait.
+func TestContract(t *testing.T) {
+	testContract(t, fakeserver.DefaultUniverse())
+}
+
+// TestContractWithUnsellableGoods checks that a hold filling up with goods no marketplace buys
+// doesn't stop the contract.
+func TestContractWithUnsellableGoods(t *testing.T) {
+	u := fakeserver.DefaultUniverse()
+	for _, wp := range u.Waypoints {
+		for symbol := range wp.Market {
+			if symbol != "FUEL" && symbol != u.ContractGood {
+				delete(wp.Market, symbol)
+			}
+		}
+	}
+	testContract(t, u)
+}
+
+func testContract(t *testing.T, u fakeserver.Universe) {
+	ctx, a, srv, clk, reg := testAppInUniverse(t, u)
+	cID := reg.Contract.Id
+	shipID := reg.Ship.Symbol
+
+	resp, _, err := a.client.ContractsApi.AcceptContract(ctx, cID).Execute()
+	if err != nil {
+		t.Fatalf("accepting contract: %v", err)
+	}
+	a.setAgent(resp.Data.Agent)
+	if got, want := a.agent.Credits, reg.Agent.Credits+reg.Contract.Terms.Payment.OnAccepted; got != want {
+		t.Errorf("credits after accepting = %d, want %d", got, want)
+	}
+	if err := a.state.Update(func(ms state.MutableState) error {
+		ms.AssignShip(cID, shipID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.loadContracts(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	as := a.augmentShip(shipID)
+	runUntilFulfilled(t, ctx, a, clk, as)
+
+	c, _ := srv.Contract(cID)
+	if !c.Fulfilled {
+		t.Errorf("server contract not fulfilled")
+	}
+	if d := c.Terms.Deliver[0]; d.UnitsFulfilled != d.UnitsRequired {
+		t.Errorf("delivered %d of %d units", d.UnitsFulfilled, d.UnitsRequired)
+	}
+	if _, ok := a.getContract(cID); ok {
+		t.Errorf("fulfilled contract still active")
+	}
+	if ships := a.state.AssignedShips(cID); len(ships) != 0 {
+		t.Errorf("ships still assigned to fulfilled contract: %v", ships)
+	}
+
+	agent, _ := srv.Agent("TESTER")
+	if a.agent.Credits != agent.Credits {
+		t.Errorf("credits = %d, server has %d", a.agent.Credits, agent.Credits)
+	}
+	if min := reg.Agent.Credits + reg.Contract.Terms.Payment.OnAccepted; agent.Credits <= min {
+		t.Errorf("credits = %d, want more than %d after fulfilment payment", agent.Credits, min)
+	}
+	ship, _ := srv.Ship(shipID)
+	if got := as.Ship().Cargo; got.Units != ship.Cargo.Units || len(got.Inventory) != len(ship.Cargo.Inventory) {
+		t.Errorf("cargo = %+v, server has %+v", got, ship.Cargo)
+	}
+	for _, item := range as.Ship().Cargo.Inventory {
+		if item.Symbol == reg.Contract.Terms.Deliver[0].TradeSymbol {
+			t.Errorf("%d units of %s left in cargo after delivering", item.Units, item.Symbol)
+		}
+	}
+}
+
+// runUntilFulfilled runs a ship's activity until it fulfils its contract, moving the clock on
+// whenever the ship has to wait.
+func runUntilFulfilled(t *testing.T, ctx context.Context, a *App, clk *clock.Fake, as *AugmentedShip) {
+	t.Helper()
+	for turn := 0; turn < 500; turn++ {
+		readyTime, err := a.shipActivity(ctx, as)
+		switch {
+		case errors.Is(err, ErrContractFulfilled):
+			return
+		case err != nil:
+			t.Fatalf("turn %d: %v", turn, err)
+		case readyTime.After(clk.Now()):
+			clk.Set(readyTime)
+		}
+	}
+	t.Fatalf("contract not fulfilled after 500 turns")
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"fivebit.co.uk/spacetraders/prompt"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+func exportProfile(profile, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := state.Export(profile, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported profile %s to %s\n", profile, path)
+	return nil
+}
+
+func importProfile(profile, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	plan, err := state.PlanImport(profile, f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Printf("Profile %s already matches %s\n", profile, path)
+		return nil
+	}
+	fmt.Printf("Importing %s into profile %s would:\n", path, profile)
+	for _, change := range plan.Changes {
+		fmt.Printf("  %s\n", change)
+	}
+	ok, err := prompt.Confirm("Import")
+	if err != nil || !ok {
+		return err
+	}
+	if err := plan.Apply(); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s into profile %s; the previous state was backed up\n", path, profile)
+	return nil
+}
+
+func restoreBackup(ctx context.Context, app *App) error {
+	backups, err := state.Backups(app.state)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Println("There are no backups")
+		return nil
+	}
+	items := []prompt.MenuItemWithResult[string]{}
+	for _, b := range backups {
+		name := b.Name
+		desc, err := state.DescribeBackup(app.state, name)
+		if err != nil {
+			desc = err.Error()
+		}
+		items = append(items, prompt.MenuItemWithResult[string]{
+			Label: fmt.Sprintf("%s: %s", b.Time.Local().Format("2006-01-02 15:04:05"), desc),
+			Fn: func() (string, error) {
+				return name, nil
+			},
+		})
+	}
+	items = append(items, prompt.MenuItemWithResult[string]{
+		Label: "Back",
+		Fn: func() (string, error) {
+			return "", nil
+		},
+	})
+	name, err := prompt.MenuWithResult("Backup to restore", items)
+	if err != nil || name == "" {
+		return err
+	}
+	ok, err := prompt.Confirm("Replace the current assignments and surveys with this backup")
+	if err != nil || !ok {
+		return err
+	}
+	if err := state.RestoreBackup(app.state, name); err != nil {
+		return err
+	}
+	fmt.Println("Backup restored; the replaced state was backed up")
+	return app.loadData(ctx)
+}
@@ -0,0 +1,289 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/prompt"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+// NoAssignment is the assignment type of ships which aren't assigned to a contract.
+const NoAssignment = ""
+
+// Behavior drives a ship in the activity loop. Behaviors are registered for the ship roles and
+// assignment types they can handle, where the assignment type is the type of the ship's contract
+// (e.g. "PROCUREMENT"), or NoAssignment.
+type Behavior interface {
+	// Name identifies the behavior in menus and in the saved state.
+	Name() string
+	Description() string
+	// Config returns a pointer to the behavior's configuration, which is saved in the state as JSON,
+	// or nil if it has none.
+	Config() any
+	// Act runs the ship's next action, returning when the ship is next ready to act, or the zero time
+	// if it is ready straight away.
+	Act(ctx context.Context, as *AugmentedShip) (time.Time, error)
+}
+
+type behaviorKey struct {
+	role       api.ShipRole
+	assignment string
+}
+
+// behaviorRegistry holds the available behaviors by name, and by the ship roles and assignment types
+// they handle. The first behavior registered for a role and assignment type is their default.
+type behaviorRegistry struct {
+	names  []string
+	byName map[string]Behavior
+	byKey  map[behaviorKey][]Behavior
+}
+
+// RegisterBehavior makes a behavior available to ships with the given roles and assignment type,
+// e.g. to register a behavior for several assignment types, call it once for each. Behaviors must be
+// registered before the App is run; their saved configuration is loaded along with the agent's data.
+func (a *App) RegisterBehavior(b Behavior, assignment string, roles ...api.ShipRole) {
+	r := &a.behaviors
+	if r.byName == nil {
+		r.byName = map[string]Behavior{}
+		r.byKey = map[behaviorKey][]Behavior{}
+	}
+	if _, ok := r.byName[b.Name()]; !ok {
+		r.names = append(r.names, b.Name())
+	}
+	r.byName[b.Name()] = b
+	for _, role := range roles {
+		key := behaviorKey{role: role, assignment: assignment}
+		r.byKey[key] = append(r.byKey[key], b)
+	}
+}
+
+// loadBehaviorConfigs decodes each behavior's saved configuration over its current configuration.
+func (a *App) loadBehaviorConfigs() error {
+	for _, name := range a.behaviors.names {
+		config := a.behaviors.byName[name].Config()
+		saved := a.state.BehaviorConfig(name)
+		if config == nil || saved == nil {
+			continue
+		}
+		if err := json.Unmarshal(saved, config); err != nil {
+			return fmt.Errorf("reading saved configuration of behavior %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// assignmentType returns the type of a ship's assignment, and false if the ship is assigned to a
+// contract which is no longer active.
+func assignmentType(as *AugmentedShip) (string, bool) {
+	if as.contractID == "" {
+		return NoAssignment, true
+	}
+	c := as.Contract()
+	if c == nil {
+		return "", false
+	}
+	return string(c.Type), true
+}
+
+// behaviorsFor returns the behaviors which can drive a ship with its role and assignment, default
+// first.
+func (a *App) behaviorsFor(as *AugmentedShip) []Behavior {
+	assignment, ok := assignmentType(as)
+	if !ok {
+		return nil
+	}
+	return a.behaviors.byKey[behaviorKey{role: as.Ship().Registration.Role, assignment: assignment}]
+}
+
+// handlesAssignment reports whether any behavior can drive ships with the given assignment type.
+func (a *App) handlesAssignment(assignment string) bool {
+	for key := range a.behaviors.byKey {
+		if key.assignment == assignment {
+			return true
+		}
+	}
+	return false
+}
+
+// shipBehavior returns the behavior chosen for a ship if it can drive the ship, or otherwise the
+// default for the ship's role and assignment. Unassigned ships have no default, so that they stay
+// idle unless a behavior is chosen for them. It returns nil if no behavior drives the ship.
+func (a *App) shipBehavior(as *AugmentedShip) Behavior {
+	candidates := a.behaviorsFor(as)
+	if len(candidates) == 0 {
+		return nil
+	}
+	chosen := a.state.ShipBehavior(as.shipID)
+	for _, b := range candidates {
+		if b.Name() == chosen {
+			return b
+		}
+	}
+	if as.contractID == "" {
+		return nil
+	}
+	return candidates[0]
+}
+
+// hasWork reports whether the activity loop has something for a ship to do: work on its active
+// contract, or whatever behavior was chosen for it while unassigned.
+func (a *App) hasWork(as *AugmentedShip) bool {
+	if as.contractID != "" {
+		return as.Contract() != nil
+	}
+	return a.shipBehavior(as) != nil
+}
+
+var cancelBehavior = prompt.MenuItemWithResult[Behavior]{
+	Label: "Cancel",
+	Fn: func() (Behavior, error) {
+		return nil, nil
+	},
+}
+
+func changeBehavior(ctx context.Context, app *App, as *AugmentedShip) error {
+	candidates := app.behaviorsFor(as)
+	if len(candidates) == 0 {
+		fmt.Println("No behaviors can drive this ship with its role and assignment")
+		return nil
+	}
+	assigned := as.contractID != ""
+	current := app.shipBehavior(as)
+	var items []prompt.MenuItem
+	for i, b := range candidates {
+		b := b
+		label := fmt.Sprintf("%s: %s", b.Name(), b.Description())
+		name := b.Name()
+		if assigned && i == 0 {
+			label += " (default)"
+			// Follow the default, even if it changes.
+			name = ""
+		}
+		if b == current {
+			label += " (current)"
+		}
+		items = append(items, prompt.MenuItem{
+			Label: label,
+			Fn: func() error {
+				return setShipBehavior(app, as, name, fmt.Sprintf("%s now uses the %s behavior", as.shipID, b.Name()))
+			},
+		})
+	}
+	if !assigned {
+		label := "none: stay idle while unassigned"
+		if current == nil {
+			label += " (current)"
+		}
+		items = append(items, prompt.MenuItem{
+			Label: label,
+			Fn: func() error {
+				return setShipBehavior(app, as, "", fmt.Sprintf("%s now stays idle while unassigned", as.shipID))
+			},
+		})
+	}
+	items = append(items, prompt.MenuItemBack)
+	return prompt.Menu("Select behavior", items)
+}
+
+// setShipBehavior saves the behavior chosen for a ship, and wakes it so that the activity loop
+// picks up the change.
+func setShipBehavior(app *App, as *AugmentedShip, name string, message string) error {
+	if err := app.state.Update(func(ms state.MutableState) error {
+		ms.SetShipBehavior(as.shipID, name)
+		return nil
+	}); err != nil {
+		return err
+	}
+	app.wakeShips(as.shipID)
+	fmt.Println(message)
+	return nil
+}
+
+// configureBehaviors shows a behavior's configuration and replaces it with JSON typed in by the
+// user, saving it in the state.
+func configureBehaviors(ctx context.Context, app *App) error {
+	var items []prompt.MenuItemWithResult[Behavior]
+	for _, name := range app.behaviors.names {
+		b := app.behaviors.byName[name]
+		if b.Config() == nil {
+			continue
+		}
+		items = append(items, prompt.MenuItemWithResult[Behavior]{
+			Label: fmt.Sprintf("%s: %s", b.Name(), b.Description()),
+			Fn: func() (Behavior, error) {
+				return b, nil
+			},
+		})
+	}
+	if len(items) == 0 {
+		fmt.Println("No behaviors have any configuration")
+		return nil
+	}
+	items = append(items, cancelBehavior)
+	b, err := prompt.MenuWithResult("Select behavior", items)
+	if err != nil || b == nil {
+		return err
+	}
+	config := b.Config()
+	current, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, current, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(buf.String())
+	input, err := prompt.Prompt("Fields to change, as JSON (empty to keep)", func(input string) error {
+		if input != "" && !json.Valid([]byte(input)) {
+			return fmt.Errorf("not valid JSON")
+		}
+		return nil
+	})
+	if err != nil || input == "" {
+		return err
+	}
+	if err := json.Unmarshal([]byte(input), config); err != nil {
+		// Undo any fields which were decoded before the error.
+		if err := json.Unmarshal(current, config); err != nil {
+			return err
+		}
+		fmt.Printf("Invalid configuration: %v\n", err)
+		return nil
+	}
+	updated, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return app.state.Update(func(ms state.MutableState) error {
+		ms.SetBehaviorConfig(b.Name(), updated)
+		return nil
+	})
+}
+
+// procurementBehavior mines the materials a procurement contract asks for, surveying first where
+// that helps, and delivers them. Its configuration is the App's Strategy.
+type procurementBehavior struct {
+	app *App
+}
+
+func (b procurementBehavior) Name() string {
+	return "procurement"
+}
+
+func (b procurementBehavior) Description() string {
+	return "mine and deliver the materials for a procurement contract"
+}
+
+func (b procurementBehavior) Config() any {
+	return &b.app.strategy
+}
+
+func (b procurementBehavior) Act(ctx context.Context, as *AugmentedShip) (time.Time, error) {
+	return b.app.procurementActivity(ctx, as)
+}
@@ -2,10 +2,12 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"text/template"
 
 	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/journal"
 	"fivebit.co.uk/spacetraders/prompt"
 	"github.com/manifoldco/promptui"
 )
@@ -100,7 +102,7 @@ func buyFromShipyard(ctx context.Context, app *App, shipyard string) (*Augmented
 	}
 	resp, _, err := app.client.SystemsApi.GetShipyard(ctx, wp.system.String(), wp.String()).Execute()
 	if err != nil {
-		return nil, err
+		return nil, decodeAPIError(err)
 	}
 	var purchaseRequest *api.PurchaseShipRequest
 	if len(resp.Data.Ships) > 0 {
@@ -116,8 +118,27 @@ func buyFromShipyard(ctx context.Context, app *App, shipyard string) (*Augmented
 	}
 
 	buyResp, _, err := app.client.FleetApi.PurchaseShip(ctx).PurchaseShipRequest(*purchaseRequest).Execute()
-	app.agent = buyResp.Data.Agent
-	app.ships[buyResp.Data.Ship.Symbol] = buyResp.Data.Ship
+	e := journal.Entry{
+		Action:  "purchase_ship",
+		Request: map[string]any{"ship_type": purchaseRequest.ShipType, "waypoint": purchaseRequest.WaypointSymbol},
+	}
+	if err != nil {
+		err = decodeAPIError(err)
+		app.record(e, err)
+		var creditsErr *InsufficientCreditsError
+		if errors.As(err, &creditsErr) {
+			fmt.Printf("Not enough credits to buy ship: %s\n", creditsErr.Message)
+			return nil, nil
+		}
+		return nil, err
+	}
+	e.Ship = buyResp.Data.Ship.Symbol
+	previous := app.setAgent(buyResp.Data.Agent)
+	e.Response = map[string]any{"credits_delta": buyResp.Data.Agent.Credits - previous.Credits}
+	app.record(e, nil)
+	app.updateShip(buyResp.Data.Ship.Symbol, func(ship *api.Ship) {
+		*ship = buyResp.Data.Ship
+	})
 	return app.augmentShip(buyResp.Data.Ship.Symbol), nil
 }
 
@@ -2,18 +2,13 @@ package app
 
 import (
 	"context"
-	"text/template"
+	"log/slog"
 
 	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/journal"
 	"fivebit.co.uk/spacetraders/state"
 )
 
-var contractFulfilledTemplate = template.Must(template.New("contract_fulfilled").Parse(`
-{{- .Contract.Type}}[{{.Contract.FactionSymbol}}]
-{{- if .Contract.Terms.Deliver}}({{range $i, $d := .Contract.Terms.Deliver}}{{if gt $i 0}}, {{end}}{{$d.TradeSymbol}}{{end}}){{end}} fulfilled{{range .Ships}}
-  {{.Ship.Registration.Name}} ({{.Ship.Registration.Role}}) now unassigned{{end}}
-`))
-
 type AugmentedContract struct {
 	Contract api.Contract
 	Ships    []api.Ship
@@ -26,21 +21,81 @@ func (ac *AugmentedContract) Active() bool {
 func (a *App) augmentContract(c api.Contract) *AugmentedContract {
 	ac := &AugmentedContract{Contract: c}
 	for _, shipID := range a.state.AssignedShips(c.Id) {
-		ac.Ships = append(ac.Ships, a.ships[shipID])
+		ship, _ := a.getShip(shipID)
+		ac.Ships = append(ac.Ships, ship)
 	}
 	return ac
 }
 
+// contractLog returns a logger annotated with the contract and the ships which will be unassigned
+// from it.
+func (a *App) contractLog(cID string) *slog.Logger {
+	c, _ := a.getContract(cID)
+	ac := a.augmentContract(c)
+	var tradeSymbols, ships []string
+	for _, d := range ac.Contract.Terms.Deliver {
+		tradeSymbols = append(tradeSymbols, d.TradeSymbol)
+	}
+	for _, s := range ac.Ships {
+		ships = append(ships, s.Symbol)
+	}
+	return a.log.With(
+		"contract", cID,
+		"type", ac.Contract.Type,
+		"faction", ac.Contract.FactionSymbol,
+		"trade_symbols", tradeSymbols,
+		"unassigned_ships", ships)
+}
+
 func (a *App) fulfillContract(ctx context.Context, cID string) error {
+	a.contractsMu.Lock()
+	defer a.contractsMu.Unlock()
+	if _, ok := a.getContract(cID); !ok {
+		// Another ship working on the contract got there first.
+		return nil
+	}
 	resp, _, err := a.client.ContractsApi.FulfillContract(ctx, cID).Execute()
 	if err != nil {
+		err = decodeAPIError(err)
+		a.record(journal.Entry{Action: "fulfill_contract", Contract: cID}, err)
 		return err
 	}
-	a.agent = resp.Data.Agent
-	printTemplate(contractFulfilledTemplate, a.augmentContract(a.activeContracts[cID]))
-	delete(a.activeContracts, cID)
-	return a.state.Update(func(ms state.MutableState) error {
+	previous := a.setAgent(resp.Data.Agent)
+	a.record(journal.Entry{
+		Action:   "fulfill_contract",
+		Contract: cID,
+		Response: map[string]any{"credits_delta": resp.Data.Agent.Credits - previous.Credits},
+	}, nil)
+	a.contractLog(cID).Info("Contract fulfilled", "credits_delta", resp.Data.Agent.Credits-previous.Credits)
+	contractsFulfilled.Inc()
+	return a.completeContract(cID)
+}
+
+// abandonContract stops working on a contract which can no longer be fulfilled, e.g. because its
+// deadline has passed.
+func (a *App) abandonContract(cID string, reason error) error {
+	a.contractsMu.Lock()
+	defer a.contractsMu.Unlock()
+	if _, ok := a.getContract(cID); !ok {
+		return nil
+	}
+	a.contractLog(cID).Warn("Contract abandoned", "reason", reason)
+	return a.completeContract(cID)
+}
+
+// completeContract unassigns a contract's ships and forgets the contract. If the state can't be
+// updated the contract is kept, so that other ships' workers treat the failure as fatal rather than
+// as the contract being finished by someone else.
+func (a *App) completeContract(cID string) error {
+	ships := a.state.AssignedShips(cID)
+	if err := a.state.Update(func(ms state.MutableState) error {
 		ms.CompleteContract(cID)
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
+	a.removeContract(cID)
+	// Let the other ships notice they have nothing left to do.
+	a.wakeShips(ships...)
+	return nil
 }
@@ -2,10 +2,13 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/state"
 )
 
 func (a *App) loadData(ctx context.Context) error {
@@ -21,15 +24,20 @@ func (a *App) loadData(ctx context.Context) error {
 	if err := a.loadWaypoints(ctx); err != nil {
 		return err
 	}
+	a.loadSurveys()
+	if err := a.loadBehaviorConfigs(); err != nil {
+		return err
+	}
+	a.updateMetrics()
 	return nil
 }
 
 func (a *App) loadAgent(ctx context.Context) error {
 	resp, _, err := a.client.AgentsApi.GetMyAgent(ctx).Execute()
 	if err != nil {
-		return err
+		return decodeAPIError(err)
 	}
-	a.agent = resp.Data
+	a.setAgent(resp.Data)
 	return nil
 }
 
@@ -39,7 +47,7 @@ func (a *App) loadShips(ctx context.Context) error {
 	for {
 		resp, _, err := a.client.FleetApi.GetMyShips(ctx).Page(page).Limit(20).Execute()
 		if err != nil {
-			return err
+			return decodeAPIError(err)
 		}
 		for _, ship := range resp.Data {
 			ships[ship.Symbol] = ship
@@ -50,7 +58,9 @@ func (a *App) loadShips(ctx context.Context) error {
 			break
 		}
 	}
+	a.mu.Lock()
 	a.ships = ships
+	a.mu.Unlock()
 	return nil
 }
 
@@ -59,54 +69,121 @@ func (a *App) loadContracts(ctx context.Context) error {
 	for _, contractID := range a.state.ActiveContracts() {
 		resp, _, err := a.client.ContractsApi.GetContract(ctx, contractID).Execute()
 		if err != nil {
+			err = decodeAPIError(err)
+			var notFoundErr *NotFoundError
+			if errors.As(err, &notFoundErr) {
+				// Reconciliation will remove it from the state.
+				continue
+			}
 			return err
 		}
 		contracts[resp.Data.Id] = resp.Data
 	}
+	a.mu.Lock()
 	a.activeContracts = contracts
+	a.mu.Unlock()
 	return nil
 }
 
 func (a *App) loadWaypoints(ctx context.Context) error {
 	systems := map[string]bool{}
+	a.mu.Lock()
 	for _, ship := range a.ships {
 		systems[ship.Nav.SystemSymbol] = true
 	}
-	waypointsBySystem := map[string][]api.Waypoint{}
+	a.waypoints = map[string][]api.Waypoint{}
+	a.mu.Unlock()
 	for system := range systems {
-		waypoints, err := a.fetchWaypoints(ctx, system)
-		if err != nil {
+		if _, err := a.getWaypoints(ctx, system); err != nil {
 			return err
 		}
-		waypointsBySystem[system] = waypoints
 	}
-	a.waypoints = waypointsBySystem
 	return nil
 }
 
 func (a *App) getWaypoints(ctx context.Context, system string) ([]api.Waypoint, error) {
-	if waypoints, ok := a.waypoints[system]; ok {
+	if waypoints, ok := a.cache.SystemWaypoints(system, a.clock.Now()); ok {
+		a.setWaypoints(system, waypoints)
 		return waypoints, nil
 	}
 	waypoints, err := a.fetchWaypoints(ctx, system)
 	if err != nil {
 		return nil, err
 	}
-	a.waypoints[system] = waypoints
+	if err := a.cache.PutSystemWaypoints(system, waypoints, a.clock.Now()); err != nil {
+		a.log.Warn("Saving waypoint cache failed", "error", err)
+	}
+	a.setWaypoints(system, waypoints)
 	return waypoints, nil
 }
 
+func (a *App) setWaypoints(system string, waypoints []api.Waypoint) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.waypoints[system] = waypoints
+}
+
 func (a *App) getWaypoint(ctx context.Context, system string, waypoint string) (api.Waypoint, error) {
-	waypoints, err := a.getWaypoints(ctx, system)
+	if wp, ok := a.cache.Waypoint(waypoint, a.clock.Now()); ok {
+		return wp, nil
+	}
+	return a.fetchWaypoint(ctx, system, waypoint)
+}
+
+// fetchWaypoint fetches a single waypoint, updating the cache and the loaded waypoints.
+func (a *App) fetchWaypoint(ctx context.Context, system string, waypoint string) (api.Waypoint, error) {
+	resp, _, err := a.client.SystemsApi.GetWaypoint(ctx, system, waypoint).Execute()
 	if err != nil {
+		err = decodeAPIError(err)
+		var notFoundErr *NotFoundError
+		if errors.As(err, &notFoundErr) {
+			return api.Waypoint{}, fmt.Errorf("Waypoint %s not found in system %s", waypoint, system)
+		}
 		return api.Waypoint{}, err
 	}
-	for _, wp := range waypoints {
-		if wp.Symbol == waypoint {
-			return wp, nil
+	wp := resp.Data
+	if err := a.cache.PutWaypoint(wp, a.clock.Now()); err != nil {
+		a.log.Warn("Saving waypoint cache failed", "error", err)
+	}
+	a.mu.Lock()
+	for i, other := range a.waypoints[system] {
+		if other.Symbol == wp.Symbol {
+			a.waypoints[system][i] = wp
+		}
+	}
+	a.mu.Unlock()
+	return wp, nil
+}
+
+// observeWaypoint refreshes the waypoint a ship is at if it was uncharted when last fetched before
+// the ship arrived, so that the cache picks up traits revealed by charting.
+func (a *App) observeWaypoint(ctx context.Context, as *AugmentedShip) error {
+	nav := as.Ship().Nav
+	wp, ok := a.cache.Waypoint(nav.WaypointSymbol, a.clock.Now())
+	if !ok || !hasTrait(wp, "UNCHARTED") || !a.cache.WaypointFetchedAt(wp.Symbol).Before(nav.Route.Arrival) {
+		return nil
+	}
+	as.log().Debug("Refreshing uncharted waypoint", "waypoint", wp.Symbol)
+	_, err := a.fetchWaypoint(ctx, nav.SystemSymbol, nav.WaypointSymbol)
+	return err
+}
+
+func hasTrait(wp api.Waypoint, trait string) bool {
+	for _, t := range wp.Traits {
+		if t.Symbol == trait {
+			return true
 		}
 	}
-	return api.Waypoint{}, fmt.Errorf("Waypoint %s not found in system %s", waypoint, system)
+	return false
+}
+
+// clearCache forgets all cached systems and waypoints and reloads them from the server.
+func clearCache(ctx context.Context, app *App) error {
+	if err := app.cache.Invalidate(); err != nil {
+		return err
+	}
+	fmt.Println("Cache cleared")
+	return app.loadData(ctx)
 }
 
 func (a *App) fetchWaypoints(ctx context.Context, system string) ([]api.Waypoint, error) {
@@ -115,7 +192,7 @@ func (a *App) fetchWaypoints(ctx context.Context, system string) ([]api.Waypoint
 	for {
 		resp, _, err := a.client.SystemsApi.GetSystemWaypoints(ctx, system).Page(page).Limit(20).Execute()
 		if err != nil {
-			return nil, err
+			return nil, decodeAPIError(err)
 		}
 		for _, wp := range resp.Data {
 			waypoints = append(waypoints, wp)
@@ -130,6 +207,8 @@ func (a *App) fetchWaypoints(ctx context.Context, system string) ([]api.Waypoint
 }
 
 func (a *App) getSurvey(waypoint string, tradeSymbol string) *api.Survey {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	surveysForWaypoint, ok := a.surveys[waypoint]
 	if !ok {
 		return nil
@@ -138,14 +217,30 @@ func (a *App) getSurvey(waypoint string, tradeSymbol string) *api.Survey {
 	if !ok {
 		return nil
 	}
-	if survey.Expiration.Before(time.Now()) {
+	if !a.clock.Now().Before(survey.Expiration) {
 		delete(surveysForWaypoint, tradeSymbol)
 		return nil
 	}
 	return survey
 }
 
-func (a *App) setSurveys(waypoint string, surveys []api.Survey) {
+// setSurveys saves new surveys for a waypoint, keeping the best survey for each material.
+func (a *App) setSurveys(waypoint string, surveys []api.Survey) error {
+	if err := a.state.Update(func(ms state.MutableState) error {
+		ms.AddSurveys(waypoint, surveys)
+		return nil
+	}); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.indexSurveys(waypoint, surveys)
+	a.mu.Unlock()
+	return nil
+}
+
+// indexSurveys records, for each material, the survey with the highest fraction of that material,
+// preferring new surveys over ones which are about to expire. It must be called with a.mu held.
+func (a *App) indexSurveys(waypoint string, surveys []api.Survey) {
 	surveysForWaypoint, ok := a.surveys[waypoint]
 	if !ok {
 		surveysForWaypoint = map[string]*api.Survey{}
@@ -154,16 +249,43 @@ func (a *App) setSurveys(waypoint string, surveys []api.Survey) {
 		}
 		a.surveys[waypoint] = surveysForWaypoint
 	}
-	for _, survey := range surveys {
+	for i := range surveys {
+		survey := surveys[i]
 		for symbol, fraction := range mineralFractions(survey) {
 			other, ok := surveysForWaypoint[symbol]
-			if !ok || other.Expiration.Sub(time.Now()) < 2 * time.Minute || fraction > mineralFractions(*other)[symbol] {
+			if !ok || other.Expiration.Sub(a.clock.Now()) < 2*time.Minute || fraction > mineralFractions(*other)[symbol] {
 				surveysForWaypoint[symbol] = &survey
 			}
 		}
 	}
 }
 
+// loadSurveys rebuilds the survey index from the surveys saved in the state.
+func (a *App) loadSurveys() {
+	saved := a.state.Surveys()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.surveys = nil
+	for waypoint, surveys := range saved {
+		a.indexSurveys(waypoint, surveys)
+	}
+}
+
+func (a *App) removeSurvey(waypoint string, signature string) error {
+	a.mu.Lock()
+	surveysForWaypoint := a.surveys[waypoint]
+	for symbol, survey := range surveysForWaypoint {
+		if survey.Signature == signature {
+			delete(surveysForWaypoint, symbol)
+		}
+	}
+	a.mu.Unlock()
+	return a.state.Update(func(ms state.MutableState) error {
+		ms.RemoveSurvey(waypoint, signature)
+		return nil
+	})
+}
+
 func mineralCounts(survey api.Survey) (map[string]int32, int32) {
 	mineralCount := map[string]int32{}
 	totalCount := int32(0)
@@ -178,12 +300,14 @@ func mineralFractions(survey api.Survey) map[string]float64 {
 	mineralCount, totalCount := mineralCounts(survey)
 	mineralFractions := map[string]float64{}
 	for symbol, count := range mineralCount {
-		mineralFractions[symbol] = float64(count)/float64(totalCount)
+		mineralFractions[symbol] = float64(count) / float64(totalCount)
 	}
 	return mineralFractions
 }
 
 func (a *App) setReadyTime(shipID string, readyTime time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	if a.shipReadyTimes == nil {
 		a.shipReadyTimes = map[string]time.Time{}
 	}
@@ -191,13 +315,126 @@ func (a *App) setReadyTime(shipID string, readyTime time.Time) {
 }
 
 func (a *App) getReadyTime(shipID string) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	readyTime := a.shipReadyTimes[shipID]
 	if readyTime.IsZero() {
 		return readyTime
 	}
-	if readyTime.Before(time.Now()) {
-		a.setReadyTime(shipID, time.Time{})
+	if !a.clock.Now().Before(readyTime) {
+		delete(a.shipReadyTimes, shipID)
 		return time.Time{}
 	}
 	return readyTime
 }
+
+// getShip returns the loaded ship with the given symbol.
+func (a *App) getShip(shipID string) (api.Ship, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	ship, ok := a.ships[shipID]
+	return ship, ok
+}
+
+// updateShip changes the loaded ship with the given symbol, e.g. with part of an API response.
+func (a *App) updateShip(shipID string, fn func(ship *api.Ship)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ship := a.ships[shipID]
+	fn(&ship)
+	a.ships[shipID] = ship
+}
+
+// getContract returns the active contract with the given ID.
+func (a *App) getContract(cID string) (api.Contract, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	c, ok := a.activeContracts[cID]
+	return c, ok
+}
+
+// updateContract replaces an active contract with a newer copy, unless it has since been fulfilled
+// or abandoned.
+func (a *App) updateContract(c api.Contract) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.activeContracts[c.Id]; ok {
+		a.activeContracts[c.Id] = c
+	}
+}
+
+// addContract makes a contract active, e.g. once it has been accepted, so that ships assigned to it
+// can work on it.
+func (a *App) addContract(c api.Contract) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.activeContracts == nil {
+		a.activeContracts = map[string]api.Contract{}
+	}
+	a.activeContracts[c.Id] = c
+}
+
+// removeContract forgets an active contract.
+func (a *App) removeContract(cID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.activeContracts, cID)
+}
+
+// setAgent records the agent from an API response, returning the agent it replaces so that callers
+// can work out the change in credits.
+func (a *App) setAgent(agent api.Agent) api.Agent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	previous := a.agent
+	a.agent = agent
+	return previous
+}
+
+// recordPrice remembers what a marketplace paid per unit of a good; 0 means it wouldn't buy it.
+func (a *App) recordPrice(waypoint string, tradeSymbol string, pricePerUnit int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.marketPrices == nil {
+		a.marketPrices = map[string]map[string]int32{}
+	}
+	if a.marketPrices[waypoint] == nil {
+		a.marketPrices[waypoint] = map[string]int32{}
+	}
+	a.marketPrices[waypoint][tradeSymbol] = pricePerUnit
+}
+
+// knownPrice returns the last price a marketplace paid per unit of a good, and false if no ship has
+// tried to sell it there.
+func (a *App) knownPrice(waypoint string, tradeSymbol string) (int32, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	price, ok := a.marketPrices[waypoint][tradeSymbol]
+	return price, ok
+}
+
+// bestKnownPrice returns the highest last price any marketplace paid per unit of a good, and false
+// if none is known to buy it.
+func (a *App) bestKnownPrice(tradeSymbol string) (int32, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	best := int32(0)
+	for _, prices := range a.marketPrices {
+		if price := prices[tradeSymbol]; price > best {
+			best = price
+		}
+	}
+	return best, best > 0
+}
+
+// surveyedMaterials returns the materials there are surveys for at a waypoint.
+func (a *App) surveyedMaterials(waypoint string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var materials []string
+	for symbol := range a.surveys[waypoint] {
+		materials = append(materials, symbol)
+	}
+	sort.Strings(materials)
+	return materials
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/clock"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+func newClockedApp() (*App, *clock.Fake) {
+	clk := clock.NewFake(testStart)
+	a := New(nil, state.NewInMemory("TESTER", "COSMIC", "token"))
+	a.SetClock(clk)
+	return a, clk
+}
+
+func TestReadyTime(t *testing.T) {
+	a, clk := newClockedApp()
+	readyTime := clk.Now().Add(time.Minute)
+	a.setReadyTime("SHIP-1", readyTime)
+
+	clk.Advance(59 * time.Second)
+	if got := a.getReadyTime("SHIP-1"); !got.Equal(readyTime) {
+		t.Errorf("ready time before it passed = %v, want %v", got, readyTime)
+	}
+	clk.Advance(time.Second)
+	if got := a.getReadyTime("SHIP-1"); !got.IsZero() {
+		t.Errorf("ready time once it passed = %v, want zero", got)
+	}
+	if got := a.getReadyTime("SHIP-2"); !got.IsZero() {
+		t.Errorf("ready time of unknown ship = %v, want zero", got)
+	}
+}
+
+func TestSurveyExpiry(t *testing.T) {
+	a, clk := newClockedApp()
+	survey := api.Survey{
+		Signature:  "SURVEY-1",
+		Symbol:     "X1-TS01-20002B",
+		Deposits:   []api.SurveyDeposit{{Symbol: "IRON_ORE"}, {Symbol: "IRON_ORE"}, {Symbol: "ICE_WATER"}},
+		Expiration: clk.Now().Add(10 * time.Minute),
+	}
+	if err := a.setSurveys(survey.Symbol, []api.Survey{survey}); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(10*time.Minute - time.Second)
+	if got := a.getSurvey(survey.Symbol, "IRON_ORE"); got == nil || got.Signature != survey.Signature {
+		t.Errorf("survey before expiry = %v, want %s", got, survey.Signature)
+	}
+	if got := a.getSurvey(survey.Symbol, "COPPER_ORE"); got != nil {
+		t.Errorf("survey for material not in it = %v, want nil", got.Signature)
+	}
+	clk.Advance(time.Second)
+	if got := a.getSurvey(survey.Symbol, "IRON_ORE"); got != nil {
+		t.Errorf("survey at expiry = %v, want nil", got.Signature)
+	}
+}
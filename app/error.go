@@ -2,32 +2,143 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 
 	"fivebit.co.uk/spacetraders/api"
 )
 
+// Error codes returned by the SpaceTraders API, from
+// https://github.com/SpaceTradersAPI/api-docs/blob/main/models/ErrorCodes.json
+const (
+	errCodeNotFound                 = 404
+	errCodeCooldown                 = 4000
+	errCodeTokenEmpty               = 4100
+	errCodeAccountHasNoAgent        = 4108
+	errCodeTokenResetDateMismatch   = 4113
+	errCodeNavigateInTransit        = 4200
+	errCodeNavigateSameDestination  = 4204
+	errCodeShipInTransit            = 4214
+	errCodePurchaseShipCredits      = 4216
+	errCodeShipCargoExceedsLimit    = 4217
+	errCodeShipSurveyVerification   = 4220
+	errCodeShipSurveyExpiration     = 4221
+	errCodeShipSurveyOrbit          = 4223
+	errCodeShipSurveyExhausted      = 4224
+	errCodeShipCargoFull            = 4228
+	errCodeShipNotInOrbit           = 4236
+	errCodeShipNotDocked            = 4244
+	errCodeContractDeadline         = 4503
+	errCodeMarketInsufficientCredit = 4600
+	errCodeMarketTradeNotSold       = 4602
+)
+
 type APIErrorResponse struct {
 	Error *APIError `json:"error"`
 }
 
 type APIError struct {
 	Code    int32                      `json:"code"`
-	Message string                      `json:"message"`
+	Message string                     `json:"message"`
 	Data    map[string]json.RawMessage `json:"data"`
 }
 
+func (ae *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", ae.Code, ae.Message)
+}
+
 func (ae *APIError) decodeData(field string, into any) error {
 	return json.Unmarshal(ae.Data[field], into)
 }
 
-func getAPIError(err error) (*APIError, error) {
-	openAPIErr, ok := err.(*api.GenericOpenAPIError)
-	if !ok {
-		return nil, err
+// CooldownError is returned when a ship action is attempted while the ship is on cooldown.
+type CooldownError struct {
+	*APIError
+	Cooldown api.Cooldown
+}
+
+type InsufficientCreditsError struct{ *APIError }
+
+type ShipNotDockedError struct{ *APIError }
+
+type ShipNotInOrbitError struct{ *APIError }
+
+type ShipInTransitError struct{ *APIError }
+
+// SameDestinationError is returned when navigating a ship to the waypoint it is already at.
+type SameDestinationError struct{ *APIError }
+
+type SurveyExhaustedError struct{ *APIError }
+
+// SurveyExpiredError is returned when extracting with a survey which has expired or is otherwise
+// no longer valid.
+type SurveyExpiredError struct{ *APIError }
+
+type CargoFullError struct{ *APIError }
+
+// TradeNotSoldError is returned when trying to sell goods which the market does not trade.
+type TradeNotSoldError struct{ *APIError }
+
+type ContractDeadlineError struct{ *APIError }
+
+// NotFoundError is returned when the requested ship, contract, waypoint etc. does not exist or
+// does not belong to the agent.
+type NotFoundError struct{ *APIError }
+
+// TokenError is returned when the agent token is missing or invalid.
+type TokenError struct{ *APIError }
+
+// TokenResetError is returned when the agent token was issued before the most recent server reset.
+type TokenResetError struct{ *APIError }
+
+// decodeAPIError converts errors returned by the API client into the typed errors above where
+// possible, or into an *APIError for other game errors. Any other errors are returned unchanged.
+func decodeAPIError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var openAPIErr *api.GenericOpenAPIError
+	if !errors.As(err, &openAPIErr) {
+		return err
 	}
 	resp := &APIErrorResponse{}
-	if err := json.Unmarshal(openAPIErr.Body(), resp); err != nil {
-		return nil, err
+	if jsonErr := json.Unmarshal(openAPIErr.Body(), resp); jsonErr != nil || resp.Error == nil {
+		return err
+	}
+	ae := resp.Error
+	switch {
+	case ae.Code == errCodeNotFound:
+		return &NotFoundError{ae}
+	case ae.Code == errCodeCooldown:
+		cd := api.Cooldown{}
+		if err := ae.decodeData("cooldown", &cd); err != nil {
+			return fmt.Errorf("decoding cooldown from %w: %v", ae, err)
+		}
+		return &CooldownError{APIError: ae, Cooldown: cd}
+	case ae.Code == errCodePurchaseShipCredits, ae.Code == errCodeMarketInsufficientCredit:
+		return &InsufficientCreditsError{ae}
+	case ae.Code == errCodeShipNotDocked:
+		return &ShipNotDockedError{ae}
+	case ae.Code == errCodeShipNotInOrbit, ae.Code == errCodeShipSurveyOrbit:
+		return &ShipNotInOrbitError{ae}
+	case ae.Code == errCodeNavigateInTransit, ae.Code == errCodeShipInTransit:
+		return &ShipInTransitError{ae}
+	case ae.Code == errCodeNavigateSameDestination:
+		return &SameDestinationError{ae}
+	case ae.Code == errCodeShipSurveyExhausted:
+		return &SurveyExhaustedError{ae}
+	case ae.Code == errCodeShipSurveyExpiration, ae.Code == errCodeShipSurveyVerification:
+		return &SurveyExpiredError{ae}
+	case ae.Code == errCodeShipCargoFull, ae.Code == errCodeShipCargoExceedsLimit:
+		return &CargoFullError{ae}
+	case ae.Code == errCodeMarketTradeNotSold:
+		return &TradeNotSoldError{ae}
+	case ae.Code == errCodeContractDeadline:
+		return &ContractDeadlineError{ae}
+	case ae.Code == errCodeTokenResetDateMismatch:
+		return &TokenResetError{ae}
+	case ae.Code >= errCodeTokenEmpty && ae.Code <= errCodeAccountHasNoAgent:
+		return &TokenError{ae}
 	}
-	return resp.Error, nil
+	return ae
 }
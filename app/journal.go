@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"fivebit.co.uk/spacetraders/journal"
+	"fivebit.co.uk/spacetraders/prompt"
+)
+
+// Actions recorded in the journal.
+var journalActions = []string{
+	"dock",
+	"orbit",
+	"navigate",
+	"extract",
+	"survey",
+	"deliver",
+	"sell",
+	"jettison",
+	"refuel",
+	"purchase_ship",
+	"purchase_cargo",
+	"accept_contract",
+	"fulfill_contract",
+}
+
+// SetJournal sets the journal which actions are recorded in.
+func (a *App) SetJournal(j *journal.Journal) {
+	a.journal = j
+}
+
+// record adds an action to the journal, noting err if it failed. Failing to write the journal is
+// logged rather than interrupting the action.
+func (a *App) record(e journal.Entry, err error) {
+	e.Time = a.clock.Now()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := a.journal.Record(e); err != nil {
+		a.log.Warn("Writing journal failed", "action", e.Action, "error", err)
+	}
+}
+
+func viewJournal(ctx context.Context, app *App) error {
+	var ships []string
+	for shipID := range app.ships {
+		ships = append(ships, shipID)
+	}
+	sort.Strings(ships)
+	filter := journal.Filter{}
+	ship, err := prompt.Select("Ship", append([]string{"Any"}, ships...))
+	if err != nil {
+		return err
+	}
+	if ship != "Any" {
+		filter.Ship = ship
+	}
+	action, err := prompt.Select("Action", append([]string{"Any"}, journalActions...))
+	if err != nil {
+		return err
+	}
+	if action != "Any" {
+		filter.Action = action
+	}
+	if filter.Since, err = promptJournalTime(app, "From (e.g. 12h ago, 2023-06-01T09:00:00Z, or blank)"); err != nil {
+		return err
+	}
+	if filter.Until, err = promptJournalTime(app, "Until (blank for now)"); err != nil {
+		return err
+	}
+
+	entries, err := app.journal.Query(filter)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	for _, e := range entries {
+		fmt.Println(formatJournalEntry(e))
+	}
+	fmt.Printf("%d entries\n\n", len(entries))
+	return nil
+}
+
+// promptJournalTime asks for a time, either absolute or as a duration before now.
+func promptJournalTime(app *App, label string) (time.Time, error) {
+	input, err := prompt.Prompt(label, func(input string) error {
+		_, err := parseJournalTime(app.clock.Now(), input)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseJournalTime(app.clock.Now(), input)
+}
+
+func parseJournalTime(now time.Time, input string) (time.Time, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(strings.TrimSuffix(input, "ago"))); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, input); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("Enter a duration such as 12h ago, or a time such as 2023-06-01T09:00:00Z")
+}
+
+func formatJournalEntry(e journal.Entry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-16s", e.Time.Local().Format("2006-01-02 15:04:05"), e.Action)
+	if e.Ship != "" {
+		fmt.Fprintf(&b, " %s", e.Ship)
+	}
+	if e.Contract != "" {
+		fmt.Fprintf(&b, " contract=%s", e.Contract)
+	}
+	for _, fields := range []map[string]any{e.Request, e.Response} {
+		var keys []string
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%v", k, fields[k])
+		}
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, " error=%q", e.Error)
+	}
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"context"
+	"math"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+// bestMarket chooses the marketplace in the ship's system to sell the goods in its cargo for which
+// sell returns true: the one where they are known to be worth most, or failing that the nearest
+// one which might buy some of them. Marketplaces beyond the ship's remaining fuel are left out. It
+// returns "" if no marketplace is worth trying.
+func (a *App) bestMarket(ctx context.Context, as *AugmentedShip, sell func(tradeSymbol string) bool) (string, error) {
+	ship := as.Ship()
+	wps, err := a.getWaypoints(ctx, ship.Nav.SystemSymbol)
+	if err != nil {
+		return "", err
+	}
+	here, err := a.getWaypoint(ctx, ship.Nav.SystemSymbol, ship.Nav.WaypointSymbol)
+	if err != nil {
+		return "", err
+	}
+	best, bestValue := "", int64(0)
+	untried, untriedDistance := "", math.Inf(1)
+	for _, wp := range wps {
+		if !hasTrait(wp, "MARKETPLACE") || !inRange(ship, here, wp) {
+			continue
+		}
+		value := int64(0)
+		unknown := false
+		for _, c := range ship.Cargo.Inventory {
+			if !sell(c.Symbol) {
+				continue
+			}
+			price, known := a.knownPrice(wp.Symbol, c.Symbol)
+			if !known {
+				unknown = true
+			}
+			value += int64(price) * int64(c.Units)
+		}
+		if value > bestValue {
+			best, bestValue = wp.Symbol, value
+		}
+		if d := distance(here, wp); unknown && d < untriedDistance {
+			untried, untriedDistance = wp.Symbol, d
+		}
+	}
+	if best != "" {
+		return best, nil
+	}
+	return untried, nil
+}
+
+// jettisonUnsellable dumps the goods in the ship's cargo for which sell returns true but which
+// every marketplace in its system has refused, since they would otherwise take up the hold for
+// good.
+func (a *App) jettisonUnsellable(ctx context.Context, as *AugmentedShip, sell func(tradeSymbol string) bool) error {
+	wps, err := a.getWaypoints(ctx, as.Ship().Nav.SystemSymbol)
+	if err != nil {
+		return err
+	}
+	for _, c := range as.Ship().Cargo.Inventory {
+		if !sell(c.Symbol) || !a.refusedEverywhere(wps, c.Symbol) {
+			continue
+		}
+		as.log().Info("Jettisoning cargo no marketplace buys", "action", "jettison", "trade_symbol", c.Symbol, "units", c.Units)
+		if err := as.Jettison(ctx, c.Symbol, c.Units); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refusedEverywhere returns whether every marketplace among the waypoints is known not to buy a
+// good.
+func (a *App) refusedEverywhere(wps []api.Waypoint, tradeSymbol string) bool {
+	markets := 0
+	for _, wp := range wps {
+		if !hasTrait(wp, "MARKETPLACE") {
+			continue
+		}
+		markets++
+		if price, known := a.knownPrice(wp.Symbol, tradeSymbol); !known || price > 0 {
+			return false
+		}
+	}
+	return markets > 0
+}
+
+// getMarket fetches a marketplace's listing, which includes prices and trade volumes if one of the
+// agent's ships is there, and remembers which goods it sells.
+func (a *App) getMarket(ctx context.Context, system, waypoint string) (api.Market, error) {
+	resp, _, err := a.client.SystemsApi.GetMarket(ctx, system, waypoint).Execute()
+	if err != nil {
+		return api.Market{}, decodeAPIError(err)
+	}
+	goods := map[string]bool{}
+	for _, g := range resp.Data.Exports {
+		goods[g.Symbol] = true
+	}
+	for _, g := range resp.Data.Exchange {
+		goods[g.Symbol] = true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.marketGoods == nil {
+		a.marketGoods = map[string]map[string]bool{}
+	}
+	a.marketGoods[waypoint] = goods
+	return resp.Data, nil
+}
+
+// marketSells reports whether a marketplace sells a good, fetching its listing if it hasn't been
+// seen yet.
+func (a *App) marketSells(ctx context.Context, system, waypoint, tradeSymbol string) (bool, error) {
+	a.mu.RLock()
+	goods, ok := a.marketGoods[waypoint]
+	a.mu.RUnlock()
+	if ok {
+		return goods[tradeSymbol], nil
+	}
+	market, err := a.getMarket(ctx, system, waypoint)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range append(market.Exports, market.Exchange...) {
+		if g.Symbol == tradeSymbol {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// nearestSupplier returns the nearest marketplace in the ship's system which sells a good and is
+// within its remaining fuel, or "" if there is none.
+func (a *App) nearestSupplier(ctx context.Context, as *AugmentedShip, tradeSymbol string) (string, error) {
+	ship := as.Ship()
+	wps, err := a.getWaypoints(ctx, ship.Nav.SystemSymbol)
+	if err != nil {
+		return "", err
+	}
+	here, err := a.getWaypoint(ctx, ship.Nav.SystemSymbol, ship.Nav.WaypointSymbol)
+	if err != nil {
+		return "", err
+	}
+	nearest, nearestDistance := "", math.Inf(1)
+	for _, wp := range wps {
+		d := distance(here, wp)
+		if !hasTrait(wp, "MARKETPLACE") || !inRange(ship, here, wp) || d >= nearestDistance {
+			continue
+		}
+		sells, err := a.marketSells(ctx, ship.Nav.SystemSymbol, wp.Symbol, tradeSymbol)
+		if err != nil {
+			return "", err
+		}
+		if sells {
+			nearest, nearestDistance = wp.Symbol, d
+		}
+	}
+	return nearest, nil
+}
+
+// inRange reports whether the ship has the fuel to travel between two waypoints. Cruising uses a
+// unit of fuel per unit of distance.
+func inRange(ship api.Ship, from, to api.Waypoint) bool {
+	return ship.Fuel.Capacity == 0 || math.Round(distance(from, to)) <= float64(ship.Fuel.Current)
+}
+
+func distance(from, to api.Waypoint) float64 {
+	return math.Hypot(float64(to.X-from.X), float64(to.Y-from.Y))
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"fivebit.co.uk/spacetraders/metrics"
+)
+
+var (
+	agentCredits = metrics.NewGauge(
+		"spacetraders_agent_credits",
+		"The agent's credits, as of the last response which included them.")
+	shipCount = metrics.NewGauge(
+		"spacetraders_ships",
+		"Ships owned by the agent, by navigation status and role.",
+		"status", "role")
+	cargoFill = metrics.NewGauge(
+		"spacetraders_ship_cargo_fill_ratio",
+		"Fraction of each ship's cargo capacity in use.",
+		"ship")
+	extractions = metrics.NewCounter(
+		"spacetraders_extractions_total",
+		"Successful resource extractions, by the trade symbol extracted.",
+		"trade_symbol")
+	extractedUnits = metrics.NewCounter(
+		"spacetraders_extracted_units_total",
+		"Units of resources extracted, by trade symbol.",
+		"trade_symbol")
+	surveysCreated = metrics.NewCounter(
+		"spacetraders_surveys_created_total",
+		"Surveys created by the agent's ships.")
+	contractsFulfilled = metrics.NewCounter(
+		"spacetraders_contracts_fulfilled_total",
+		"Contracts fulfilled.")
+	activityErrors = metrics.NewCounter(
+		"spacetraders_activity_errors_total",
+		"Errors from rounds of the activity loop, by whether the loop retried (transient) or stopped (fatal).",
+		"kind")
+)
+
+// updateMetrics sets the gauges derived from the agent and ships. Gauges are updated after each
+// change rather than read at scrape time, so the App's maps are never read while being modified.
+// The per-ship gauges are rebuilt in full and swapped in, so ships which are gone are dropped.
+func (a *App) updateMetrics() {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	agentCredits.Set(float64(a.agent.Credits))
+	ships := shipCount.Batch()
+	fill := cargoFill.Batch()
+	for _, s := range a.ships {
+		ships.Add(1, string(s.Nav.Status), string(s.Registration.Role))
+		if s.Cargo.Capacity > 0 {
+			fill.Set(float64(s.Cargo.Units)/float64(s.Cargo.Capacity), s.Symbol)
+		}
+	}
+	shipCount.Replace(ships)
+	cargoFill.Replace(fill)
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+// MineAndSellConfig is the configuration of the mine-and-sell behavior.
+type MineAndSellConfig struct {
+	// SellCargoThreshold is the fraction of the cargo hold above which ships stop extracting and go
+	// to sell what they have.
+	SellCargoThreshold float64 `json:"sellCargoThreshold"`
+	// Keep lists trade symbols which are never sold.
+	Keep []string `json:"keep"`
+}
+
+func DefaultMineAndSellConfig() MineAndSellConfig {
+	return MineAndSellConfig{
+		SellCargoThreshold: 0.85,
+		Keep:               []string{"ANTIMATTER"},
+	}
+}
+
+// mineAndSellBehavior extracts resources at the nearest asteroid field, surveying first if the ship
+// has a surveyor, and sells them at the best known marketplace, refuelling there before returning.
+// Goods which no marketplace in the system buys are jettisoned.
+// Ships without a contract only use it once it has been chosen for them.
+type mineAndSellBehavior struct {
+	app    *App
+	config MineAndSellConfig
+}
+
+func (b *mineAndSellBehavior) Name() string {
+	return "mine-and-sell"
+}
+
+func (b *mineAndSellBehavior) Description() string {
+	return "mine at the nearest asteroid field and sell at the best known marketplace"
+}
+
+func (b *mineAndSellBehavior) Config() any {
+	return &b.config
+}
+
+func (b *mineAndSellBehavior) Act(ctx context.Context, as *AugmentedShip) (time.Time, error) {
+	a := b.app
+	readyTime, err := a.checkShipTransit(ctx, as)
+	if err != nil || !readyTime.IsZero() {
+		return readyTime, err
+	}
+
+	waypointTraits, err := a.getCurrentWaypointTraits(ctx, as)
+	if err != nil {
+		return time.Time{}, err
+	}
+	here := as.Ship().Nav.WaypointSymbol
+
+	if waypointTraits["MARKETPLACE"] {
+		if as.Ship().Fuel.Current < as.Ship().Fuel.Capacity {
+			as.log().Info("Refuelling", "action", "refuel")
+			if err := as.TryRefuel(ctx); err != nil {
+				return time.Time{}, err
+			}
+		}
+		for _, c := range as.Ship().Cargo.Inventory {
+			if b.keep(c.Symbol) {
+				continue
+			}
+			if price, known := a.knownPrice(here, c.Symbol); known && price == 0 {
+				continue
+			}
+			as.log().Info("Selling cargo", "action", "sell", "trade_symbol", c.Symbol, "units", c.Units)
+			if err := as.SellCargo(ctx, c.Symbol, c.Units); err != nil {
+				var notSoldErr *TradeNotSoldError
+				if errors.As(err, &notSoldErr) {
+					as.log().Warn("Market does not buy cargo", "action", "sell", "trade_symbol", c.Symbol, "units", c.Units)
+					continue
+				}
+				return time.Time{}, err
+			}
+		}
+	}
+
+	if err := a.jettisonUnsellable(ctx, as, b.sells); err != nil {
+		return time.Time{}, err
+	}
+
+	// Kept goods never leave the hold, so the threshold applies to the space left for the rest.
+	ship := as.Ship()
+	kept := b.keptUnits(ship.Cargo)
+	if kept >= ship.Cargo.Capacity {
+		return time.Time{}, fmt.Errorf("%w: cargo hold is full of goods to keep", ErrShipIdle)
+	}
+	if float64(ship.Cargo.Units-kept)/float64(ship.Cargo.Capacity-kept) >= b.config.SellCargoThreshold {
+		market, err := a.bestMarket(ctx, as, b.sells)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if market == "" || market == here {
+			return time.Time{}, fmt.Errorf("%w: no known marketplace buys the cargo", ErrShipIdle)
+		}
+		as.log().Info("Travelling to sell cargo", "action", "navigate", "destination", market)
+		return as.TravelTo(ctx, market)
+	}
+
+	if waypointTraits["MINERAL_DEPOSITS"] {
+		target := b.target(here)
+		if target == "" && as.HasMount("MOUNT_SURVEYOR") {
+			as.log().Info("Surveying", "action", "survey")
+			return as.Survey(ctx)
+		}
+		as.log().Info("Extracting", "action", "extract", "target", target)
+		return as.Extract(ctx, target)
+	}
+
+	field, err := b.nearestField(ctx, as)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if field == "" {
+		return time.Time{}, fmt.Errorf("%w: no asteroid field in system %s", ErrShipIdle, ship.Nav.SystemSymbol)
+	}
+	as.log().Info("Travelling to extract resources", "action", "navigate", "destination", field)
+	return as.TravelTo(ctx, field)
+}
+
+func (b *mineAndSellBehavior) keep(tradeSymbol string) bool {
+	for _, k := range b.config.Keep {
+		if k == tradeSymbol {
+			return true
+		}
+	}
+	return false
+}
+
+// sells reports whether the behavior sells a good, rather than keeping it.
+func (b *mineAndSellBehavior) sells(tradeSymbol string) bool {
+	return !b.keep(tradeSymbol)
+}
+
+// keptUnits returns the number of units of cargo which are never sold.
+func (b *mineAndSellBehavior) keptUnits(cargo api.ShipCargo) int32 {
+	units := int32(0)
+	for _, c := range cargo.Inventory {
+		if b.keep(c.Symbol) {
+			units += c.Units
+		}
+	}
+	return units
+}
+
+// target chooses the material to extract at a waypoint: the one whose best survey is worth most,
+// going by the fraction of its deposits and the best known price. It returns "" if there are no
+// usable surveys.
+func (b *mineAndSellBehavior) target(waypoint string) string {
+	best, bestValue := "", 0.0
+	for _, material := range b.app.surveyedMaterials(waypoint) {
+		if b.keep(material) {
+			continue
+		}
+		survey := b.app.getSurvey(waypoint, material)
+		if survey == nil {
+			continue
+		}
+		price := 1.0
+		if p, ok := b.app.bestKnownPrice(material); ok {
+			price = float64(p)
+		}
+		if value := mineralFractions(*survey)[material] * price; value > bestValue {
+			best, bestValue = material, value
+		}
+	}
+	return best
+}
+
+// nearestField returns the nearest waypoint with mineral deposits in the ship's system, or "" if
+// there is none.
+func (b *mineAndSellBehavior) nearestField(ctx context.Context, as *AugmentedShip) (string, error) {
+	ship := as.Ship()
+	wps, err := b.app.getWaypoints(ctx, ship.Nav.SystemSymbol)
+	if err != nil {
+		return "", err
+	}
+	here, err := b.app.getWaypoint(ctx, ship.Nav.SystemSymbol, ship.Nav.WaypointSymbol)
+	if err != nil {
+		return "", err
+	}
+	nearest, nearestDistance := "", math.Inf(1)
+	for _, wp := range wps {
+		if d := distance(here, wp); hasTrait(wp, "MINERAL_DEPOSITS") && d < nearestDistance {
+			nearest, nearestDistance = wp.Symbol, d
+		}
+	}
+	return nearest, nil
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"testing"
+
+	"fivebit.co.uk/spacetraders/fakeserver"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+// TestMineAndSellJettisonsUnsellable runs the command ship's mine-and-sell behavior in a universe
+// where no marketplace buys ice water, checking that the ship keeps earning rather than filling its
+// hold with it.
+func TestMineAndSellJettisonsUnsellable(t *testing.T) {
+	u := fakeserver.DefaultUniverse()
+	for _, wp := range u.Waypoints {
+		delete(wp.Market, "ICE_WATER")
+	}
+	ctx, a, srv, clk, reg := testAppInUniverse(t, u)
+	shipID := reg.Ship.Symbol
+	if err := a.state.Update(func(ms state.MutableState) error {
+		ms.SetShipBehavior(shipID, "mine-and-sell")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	as := a.augmentShip(shipID)
+	for turn := 0; turn < 300; turn++ {
+		readyTime, err := a.shipActivity(ctx, as)
+		if err != nil {
+			t.Fatalf("turn %d: %v", turn, err)
+		}
+		if readyTime.After(clk.Now()) {
+			clk.Set(readyTime)
+		}
+	}
+
+	for _, market := range []string{fakeserver.Headquarters, fakeserver.TradingPost} {
+		if price, known := a.knownPrice(market, "ICE_WATER"); !known || price != 0 {
+			t.Errorf("ice water price at %s = %d (known %t), want known refusal", market, price, known)
+		}
+	}
+	agent, _ := srv.Agent("TESTER")
+	if agent.Credits <= reg.Agent.Credits {
+		t.Errorf("credits = %d, want more than the starting %d", agent.Credits, reg.Agent.Credits)
+	}
+	ship, _ := srv.Ship(shipID)
+	for _, item := range ship.Cargo.Inventory {
+		if item.Symbol == "ICE_WATER" && item.Units > ship.Cargo.Capacity/2 {
+			t.Errorf("%d units of ice water in the hold", item.Units)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"fivebit.co.uk/spacetraders/prompt"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+// switchProfileError is returned from the main menu to load a different profile in place of the
+// current one.
+type switchProfileError struct {
+	profile string
+}
+
+func (e *switchProfileError) Error() string {
+	return fmt.Sprintf("switching to profile %s", e.profile)
+}
+
+// SetProfile records the name of the profile the App's state was loaded from.
+func (a *App) SetProfile(name string) {
+	a.profile = name
+}
+
+func manageProfiles(ctx context.Context, app *App) error {
+	return prompt.Menu(fmt.Sprintf("Profiles (current: %s)", app.profile), []prompt.MenuItem{
+		app.MenuItem(ctx, "List profiles", listProfiles),
+		{Label: "Switch profile", Fn: app.switchProfile},
+		{Label: "Create profile", Fn: app.createProfile},
+		app.MenuItem(ctx, "Delete profile", deleteProfile),
+		prompt.MenuItemBack,
+	})
+}
+
+func listProfiles(_ context.Context, app *App) error {
+	profiles, err := state.Profiles()
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		if p == app.profile {
+			fmt.Printf("* %s\n", p)
+		} else {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	return nil
+}
+
+func (a *App) switchProfile() error {
+	profiles, err := state.Profiles()
+	if err != nil {
+		return err
+	}
+	var others []string
+	for _, p := range profiles {
+		if p != a.profile {
+			others = append(others, p)
+		}
+	}
+	if len(others) == 0 {
+		fmt.Println("There are no other profiles")
+		return nil
+	}
+	profile, err := prompt.Select("Profile", others)
+	if err != nil {
+		return err
+	}
+	return &switchProfileError{profile: profile}
+}
+
+func (a *App) createProfile() error {
+	name, err := prompt.Prompt("Profile name", func(input string) error {
+		if err := state.ValidateProfileName(input); err != nil {
+			return err
+		}
+		if ok, err := state.ProfileExists(input); err != nil {
+			return err
+		} else if ok {
+			return errors.New("Profile already exists")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Loading a profile with no saved agent registers a new one.
+	return &switchProfileError{profile: name}
+}
+
+func deleteProfile(_ context.Context, app *App) error {
+	profiles, err := state.Profiles()
+	if err != nil {
+		return err
+	}
+	var deletable []string
+	for _, p := range profiles {
+		if p != app.profile && p != state.DefaultProfile {
+			deletable = append(deletable, p)
+		}
+	}
+	if len(deletable) == 0 {
+		fmt.Println("There are no profiles which can be deleted")
+		return nil
+	}
+	profile, err := prompt.Select("Profile to delete", deletable)
+	if err != nil {
+		return err
+	}
+	ok, err := prompt.Confirm(fmt.Sprintf("Delete profile %s, including its agent token", profile))
+	if err != nil || !ok {
+		return err
+	}
+	if err := state.DeleteProfile(profile); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted profile %s\n", profile)
+	return nil
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"fivebit.co.uk/spacetraders/prompt"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+// reconcileAssignments compares the ship and contract assignments in the state with the ships and
+// contracts loaded from the server, returning corrected assignments and a description of each
+// change. It removes contracts which no longer exist, have been fulfilled or have expired, ships
+// which the agent no longer owns, duplicate entries, and ships listed under more than one contract.
+func (a *App) reconcileAssignments() (map[string]string, map[string][]string, []string) {
+	ships, contracts := a.state.Assignments()
+	var changes []string
+	now := a.clock.Now()
+
+	var contractIDs []string
+	for cID := range contracts {
+		contractIDs = append(contractIDs, cID)
+	}
+	sort.Strings(contractIDs)
+
+	valid := map[string]bool{}
+	for _, cID := range contractIDs {
+		c, ok := a.activeContracts[cID]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("Contract %s no longer exists; removing it", cID))
+		case c.Fulfilled:
+			changes = append(changes, fmt.Sprintf("Contract %s has been fulfilled; removing it", cID))
+		case c.Accepted && !now.Before(c.Terms.Deadline):
+			changes = append(changes, fmt.Sprintf("Contract %s passed its deadline at %s; removing it", cID, c.Terms.Deadline))
+		case !c.Accepted && !now.Before(c.Expiration):
+			changes = append(changes, fmt.Sprintf("Contract %s expired at %s without being accepted; removing it", cID, c.Expiration))
+		default:
+			valid[cID] = true
+		}
+	}
+
+	// Each ship keeps the contract recorded for it if that contract is valid and lists it, and
+	// otherwise the first valid contract which lists it.
+	owner := map[string]string{}
+	for shipID, cID := range ships {
+		if valid[cID] && contains(contracts[cID], shipID) {
+			owner[shipID] = cID
+		}
+	}
+	for _, cID := range contractIDs {
+		if !valid[cID] {
+			continue
+		}
+		for _, shipID := range contracts[cID] {
+			if _, ok := owner[shipID]; !ok {
+				owner[shipID] = cID
+			}
+		}
+	}
+
+	newShips := map[string]string{}
+	newContracts := map[string][]string{}
+	// reported records ships whose changes have already been described.
+	reported := map[string]bool{}
+	for _, cID := range contractIDs {
+		if !valid[cID] {
+			continue
+		}
+		kept := []string{}
+		for _, shipID := range contracts[cID] {
+			switch {
+			case contains(kept, shipID):
+				changes = append(changes, fmt.Sprintf("Ship %s is listed more than once for contract %s; removing duplicates", shipID, cID))
+			case a.ships[shipID].Symbol == "":
+				if !reported[shipID] {
+					changes = append(changes, fmt.Sprintf("Ship %s is no longer owned; unassigning it", shipID))
+				}
+				reported[shipID] = true
+			case owner[shipID] != cID:
+				changes = append(changes, fmt.Sprintf("Ship %s is assigned to both %s and %s; keeping it on %s", shipID, owner[shipID], cID, owner[shipID]))
+				reported[shipID] = true
+			default:
+				kept = append(kept, shipID)
+				newShips[shipID] = cID
+			}
+		}
+		newContracts[cID] = kept
+	}
+
+	var shipIDs []string
+	for shipID := range ships {
+		shipIDs = append(shipIDs, shipID)
+	}
+	sort.Strings(shipIDs)
+	for _, shipID := range shipIDs {
+		cID := ships[shipID]
+		if newShips[shipID] == cID || !valid[cID] || reported[shipID] {
+			// Unchanged, or already reported along with its contract or another change.
+			continue
+		}
+		if newShips[shipID] == "" {
+			changes = append(changes, fmt.Sprintf("Ship %s was assigned to contract %s, which does not list it; unassigning it", shipID, cID))
+		} else {
+			changes = append(changes, fmt.Sprintf("Ship %s was assigned to contract %s, but is listed under %s; moving it", shipID, cID, newShips[shipID]))
+		}
+	}
+	return newShips, newContracts, changes
+}
+
+// reconcile corrects the state's assignments to match the server, asking for confirmation first
+// unless autoReconcile is set, and reports what it changed.
+func (a *App) reconcile() error {
+	ships, contracts, changes := a.reconcileAssignments()
+	if len(changes) == 0 {
+		return nil
+	}
+	fmt.Println("The saved state does not match the server:")
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	if !a.autoReconcile {
+		ok, err := prompt.Confirm("Apply these changes")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("State left unchanged")
+			return nil
+		}
+	}
+	err := a.state.Update(func(ms state.MutableState) error {
+		ms.SetAssignments(ships, contracts)
+		return nil
+	})
+	if errors.Is(err, state.ErrReadOnly) {
+		fmt.Println("State is read-only; changes not applied")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for cID := range a.activeContracts {
+		if _, ok := contracts[cID]; !ok {
+			delete(a.activeContracts, cID)
+		}
+	}
+	fmt.Printf("Applied %d changes to the state\n", len(changes))
+	return nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
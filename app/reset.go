@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/prompt"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+// ErrNotReregistered is returned when the agent was lost in a server reset, but re-registering it was
+// declined.
+var ErrNotReregistered = errors.New("the server has been reset since this agent was registered; restart to re-register it")
+
+// checkServerReset compares the server's reset date with the one the agent was registered after,
+// re-registering the agent if the server has been reset since. Failing to get the server status is
+// not fatal, since the token may still work.
+func checkServerReset(ctx context.Context, client *api.APIClient, s state.State, auto bool) (state.State, error) {
+	resetDate, err := state.ServerResetDate(ctx, client)
+	if err != nil {
+		fmt.Printf("Could not check the server status: %v\n", decodeAPIError(err))
+		return s, nil
+	}
+	switch s.GetResetDate() {
+	case resetDate:
+		return s, nil
+	case "":
+		// Saved before reset dates were recorded; assume the agent is current; if not, the token will
+		// be rejected.
+		err := s.Update(func(ms state.MutableState) error {
+			ms.SetResetDate(resetDate)
+			return nil
+		})
+		if err != nil && !errors.Is(err, state.ErrReadOnly) {
+			return nil, err
+		}
+		return s, nil
+	}
+	return reregister(ctx, client, s, auto, fmt.Sprintf("The server was reset on %s, since this agent was registered (after the reset on %s)", resetDate, s.GetResetDate()))
+}
+
+// reregister archives the state and registers the agent again, after confirmation unless auto is
+// set.
+func reregister(ctx context.Context, client *api.APIClient, s state.State, auto bool, reason string) (state.State, error) {
+	fmt.Println(reason)
+	if !auto {
+		ok, err := prompt.Confirm("Archive the saved state and re-register the agent")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrNotReregistered
+		}
+	}
+	return state.Reregister(ctx, client, s)
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"fivebit.co.uk/spacetraders/clock"
+)
+
+// readyShip is a ship waiting in the scheduler's queue.
+type readyShip struct {
+	shipID string
+	at     time.Time
+	index  int
+}
+
+// readyQueue is a priority queue of ships, ordered by when they are next ready to act.
+type readyQueue []*readyShip
+
+func (q readyQueue) Len() int {
+	return len(q)
+}
+
+func (q readyQueue) Less(i, j int) bool {
+	return q[i].at.Before(q[j].at)
+}
+
+func (q readyQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *readyQueue) Push(x any) {
+	r := x.(*readyShip)
+	r.index = len(*q)
+	*q = append(*q, r)
+}
+
+func (q *readyQueue) Pop() any {
+	old := *q
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return r
+}
+
+// scheduler wakes each ship exactly when it is next ready to act, e.g. when it arrives or its
+// cooldown expires, using a single timer for the earliest ship in the queue. Ships returned by due
+// are acting until they are returned to the queue with done, or dropped.
+type scheduler struct {
+	clock clock.Clock
+	// timer fires when the earliest queued ship is ready; it is reset whenever the queue changes.
+	timer  clock.Timer
+	mu     sync.Mutex
+	queue  readyQueue
+	queued map[string]*readyShip
+	acting map[string]bool
+	// woken holds acting ships which were woken by an event, and so act again as soon as they are
+	// done.
+	woken map[string]bool
+}
+
+func newScheduler(c clock.Clock) *scheduler {
+	return &scheduler{
+		clock:  c,
+		timer:  c.NewTimer(0),
+		queued: map[string]*readyShip{},
+		acting: map[string]bool{},
+		woken:  map[string]bool{},
+	}
+}
+
+// wake queues a ship to act as soon as possible, e.g. because it was assigned or its contract
+// changed. A ship which is acting acts again as soon as it is done.
+func (s *scheduler) wake(shipID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.acting[shipID] {
+		s.woken[shipID] = true
+		return
+	}
+	s.put(shipID, time.Time{})
+	s.rearm()
+}
+
+// done returns an acting ship to the queue, to act again at the given time; the zero time means as
+// soon as possible.
+func (s *scheduler) done(shipID string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.acting, shipID)
+	if s.woken[shipID] {
+		delete(s.woken, shipID)
+		at = time.Time{}
+	}
+	s.put(shipID, at)
+	s.rearm()
+}
+
+// drop stops scheduling an acting ship, e.g. because it has nothing left to do, until it is woken
+// again.
+func (s *scheduler) drop(shipID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.acting, shipID)
+	delete(s.woken, shipID)
+	s.rearm()
+}
+
+// due removes the ships which are ready to act by now from the queue, and marks them as acting.
+func (s *scheduler) due() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	var ships []string
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		r := heap.Pop(&s.queue).(*readyShip)
+		delete(s.queued, r.shipID)
+		s.acting[r.shipID] = true
+		ships = append(ships, r.shipID)
+	}
+	s.rearm()
+	return ships
+}
+
+// idle reports whether no ship is acting.
+func (s *scheduler) idle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.acting) == 0
+}
+
+// put must be called with s.mu held.
+func (s *scheduler) put(shipID string, at time.Time) {
+	if r, ok := s.queued[shipID]; ok {
+		r.at = at
+		heap.Fix(&s.queue, r.index)
+		return
+	}
+	r := &readyShip{shipID: shipID, at: at}
+	heap.Push(&s.queue, r)
+	s.queued[shipID] = r
+}
+
+// rearm sets the timer for the earliest queued ship. It must be called with s.mu held, so that the
+// timer always matches the queue.
+func (s *scheduler) rearm() {
+	if len(s.queue) == 0 {
+		s.timer.Stop()
+		return
+	}
+	s.timer.Reset(s.queue[0].at.Sub(s.clock.Now()))
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+
+	"fivebit.co.uk/spacetraders/config"
+	"fivebit.co.uk/spacetraders/prompt"
+	"fivebit.co.uk/spacetraders/secret"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+// EnvTokenPassphrase is the environment variable holding the passphrase for an encrypted token
+// file. If it is not set, the passphrase is asked for.
+const EnvTokenPassphrase = "SPACETRADERS_TOKEN_PASSPHRASE"
+
+// tokenProvider returns the configured secret provider for a profile's token, or nil if the token
+// is kept in the state file.
+func tokenProvider(cfg config.Token, profile string) (secret.Provider, error) {
+	if cfg.Provider == "" || cfg.Provider == config.TokenProviderState {
+		return nil, nil
+	}
+	dir, err := state.ProfileDir(profile)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Provider {
+	case config.TokenProviderEnv:
+		name := cfg.Env
+		if name == "" {
+			name = "SPACETRADERS_TOKEN"
+		}
+		return secret.Env{Var: name}, nil
+	case config.TokenProviderFile:
+		return secret.File{Path: filepath.Join(dir, "token")}, nil
+	default:
+		return secret.Encrypted{Path: filepath.Join(dir, "token.enc"), Passphrase: tokenPassphrase}, nil
+	}
+}
+
+func tokenPassphrase() (string, error) {
+	if passphrase, ok := os.LookupEnv(EnvTokenPassphrase); ok {
+		return passphrase, nil
+	}
+	return prompt.Password("Token passphrase")
+}
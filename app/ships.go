@@ -2,12 +2,15 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"text/template"
 	"time"
 
 	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/journal"
 )
 
 var shipShortTemplate = template.Must(template.New("ship_short").Parse(`
@@ -34,18 +37,25 @@ type AugmentedShip struct {
 	app        *App
 	shipID     string
 	contractID string
+	// contract is the ship's contract as of when it was augmented, for when another ship fulfils or
+	// abandons it in the meantime.
+	contract *api.Contract
 }
 
 func (a *App) augmentShip(shipID string) *AugmentedShip {
-	return &AugmentedShip{
+	as := &AugmentedShip{
 		app:        a,
 		shipID:     shipID,
 		contractID: a.state.AssignedContract(shipID),
 	}
+	if c, ok := a.getContract(as.contractID); ok {
+		as.contract = &c
+	}
+	return as
 }
 
 func (as *AugmentedShip) Ship() api.Ship {
-	s, ok := as.app.ships[as.shipID]
+	s, ok := as.app.getShip(as.shipID)
 	if !ok {
 		panic(fmt.Errorf("ship %s not found", as.shipID))
 	}
@@ -56,41 +66,66 @@ func (as *AugmentedShip) Contract() *api.Contract {
 	if as.contractID == "" {
 		return nil
 	}
-	c, ok := as.app.activeContracts[as.contractID]
+	c, ok := as.app.getContract(as.contractID)
 	if !ok {
-		panic(fmt.Errorf("contract %s not found", as.contractID))
+		return as.contract
 	}
 	return &c
 }
 
+// record adds an action by this ship to the journal.
+func (as *AugmentedShip) record(e journal.Entry, err error) {
+	e.Ship = as.shipID
+	if e.Contract == "" {
+		e.Contract = as.contractID
+	}
+	as.app.record(e, err)
+}
+
+// log returns a logger annotated with the ship, its role, contract and current location.
+func (as *AugmentedShip) log() *slog.Logger {
+	ship := as.Ship()
+	return as.app.log.With(
+		"ship", ship.Symbol,
+		"role", ship.Registration.Role,
+		"contract", as.contractID,
+		"waypoint", ship.Nav.WaypointSymbol)
+}
+
 func (as *AugmentedShip) Refresh(ctx context.Context) error {
 	resp, _, err := as.app.client.FleetApi.GetMyShip(ctx, as.shipID).Execute()
 	if err != nil {
-		return err
+		return decodeAPIError(err)
 	}
-	as.app.ships[as.shipID] = resp.Data
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		*ship = resp.Data
+	})
 	return nil
 }
 
 func (as *AugmentedShip) Dock(ctx context.Context) error {
 	resp, _, err := as.app.client.FleetApi.DockShip(ctx, as.shipID).Execute()
+	err = decodeAPIError(err)
+	as.record(journal.Entry{Action: "dock"}, err)
 	if err != nil {
 		return err
 	}
-	ship := as.app.ships[as.shipID]
-	ship.Nav = resp.Data.Nav
-	as.app.ships[as.shipID] = ship
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Nav = resp.Data.Nav
+	})
 	return nil
 }
 
 func (as *AugmentedShip) Orbit(ctx context.Context) error {
 	resp, _, err := as.app.client.FleetApi.OrbitShip(ctx, as.shipID).Execute()
+	err = decodeAPIError(err)
+	as.record(journal.Entry{Action: "orbit"}, err)
 	if err != nil {
 		return err
 	}
-	ship := as.app.ships[as.shipID]
-	ship.Nav = resp.Data.Nav
-	as.app.ships[as.shipID] = ship
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Nav = resp.Data.Nav
+	})
 	return nil
 }
 
@@ -102,13 +137,23 @@ func (as *AugmentedShip) TryRefuel(ctx context.Context) error {
 	}
 	resp, _, err := as.app.client.FleetApi.RefuelShip(ctx, as.shipID).Execute()
 	if err != nil {
-		fmt.Printf("Failed to refuel ship: %v", err)
+		err = decodeAPIError(err)
+		as.record(journal.Entry{Action: "refuel"}, err)
+		as.log().Warn("Failed to refuel", "action", "refuel", "error", err)
 		return nil
 	}
-	ship := as.app.ships[as.shipID]
-	ship.Fuel = resp.Data.Fuel
-	as.app.ships[as.shipID] = ship
-	as.app.agent = resp.Data.Agent
+	previous := as.app.setAgent(resp.Data.Agent)
+	as.record(journal.Entry{
+		Action: "refuel",
+		Response: map[string]any{
+			"units":         resp.Data.Transaction.Units,
+			"credits_delta": resp.Data.Agent.Credits - previous.Credits,
+		},
+	}, nil)
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Fuel = resp.Data.Fuel
+	})
+	as.log().Info("Refuelled", "action", "refuel", "units", resp.Data.Transaction.Units, "credits_delta", resp.Data.Agent.Credits-previous.Credits)
 	return nil
 }
 
@@ -123,13 +168,25 @@ func (as *AugmentedShip) DeliverGoods(ctx context.Context, tradeSymbol string, u
 		TradeSymbol: tradeSymbol,
 		Units:       units,
 	}).Execute()
+	e := journal.Entry{
+		Action:  "deliver",
+		Request: map[string]any{"trade_symbol": tradeSymbol, "units": units},
+	}
 	if err != nil {
+		err = decodeAPIError(err)
+		as.record(e, err)
 		return err
 	}
-	ship := as.app.ships[as.shipID]
-	ship.Cargo = resp.Data.Cargo
-	as.app.ships[as.shipID] = ship
-	as.app.activeContracts[as.contractID] = resp.Data.Contract
+	for _, d := range resp.Data.Contract.Terms.Deliver {
+		if d.TradeSymbol == tradeSymbol && d.DestinationSymbol == as.Ship().Nav.WaypointSymbol {
+			e.Response = map[string]any{"units_fulfilled": d.UnitsFulfilled, "units_required": d.UnitsRequired}
+		}
+	}
+	as.record(e, nil)
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Cargo = resp.Data.Cargo
+	})
+	as.app.updateContract(resp.Data.Contract)
 	return nil
 }
 
@@ -143,13 +200,87 @@ func (as *AugmentedShip) SellCargo(ctx context.Context, tradeSymbol string, unit
 		Symbol: tradeSymbol,
 		Units:  units,
 	}).Execute()
+	e := journal.Entry{
+		Action:  "sell",
+		Request: map[string]any{"trade_symbol": tradeSymbol, "units": units},
+	}
+	if err != nil {
+		err = decodeAPIError(err)
+		as.record(e, err)
+		var notSoldErr *TradeNotSoldError
+		if errors.As(err, &notSoldErr) {
+			as.app.recordPrice(as.Ship().Nav.WaypointSymbol, tradeSymbol, 0)
+		}
+		return err
+	}
+	as.app.recordPrice(as.Ship().Nav.WaypointSymbol, tradeSymbol, resp.Data.Transaction.PricePerUnit)
+	previous := as.app.setAgent(resp.Data.Agent)
+	e.Response = map[string]any{
+		"price_per_unit": resp.Data.Transaction.PricePerUnit,
+		"total_price":    resp.Data.Transaction.TotalPrice,
+		"credits_delta":  resp.Data.Agent.Credits - previous.Credits,
+	}
+	as.record(e, nil)
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Cargo = resp.Data.Cargo
+	})
+	as.log().Info("Sold cargo", "action", "sell", "trade_symbol", tradeSymbol, "units", units, "credits_delta", resp.Data.Agent.Credits-previous.Credits)
+	return nil
+}
+
+// PurchaseCargo buys goods at the marketplace the ship is at.
+func (as *AugmentedShip) PurchaseCargo(ctx context.Context, tradeSymbol string, units int32) error {
+	if as.Ship().Nav.Status != api.SHIPNAVSTATUS_DOCKED {
+		if err := as.Dock(ctx); err != nil {
+			return err
+		}
+	}
+	resp, _, err := as.app.client.FleetApi.PurchaseCargo(ctx, as.shipID).PurchaseCargoRequest(api.PurchaseCargoRequest{
+		Symbol: tradeSymbol,
+		Units:  units,
+	}).Execute()
+	e := journal.Entry{
+		Action:  "purchase_cargo",
+		Request: map[string]any{"trade_symbol": tradeSymbol, "units": units},
+	}
+	if err != nil {
+		err = decodeAPIError(err)
+		as.record(e, err)
+		return err
+	}
+	previous := as.app.setAgent(resp.Data.Agent)
+	e.Response = map[string]any{
+		"price_per_unit": resp.Data.Transaction.PricePerUnit,
+		"total_price":    resp.Data.Transaction.TotalPrice,
+		"credits_delta":  resp.Data.Agent.Credits - previous.Credits,
+	}
+	as.record(e, nil)
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Cargo = resp.Data.Cargo
+	})
+	as.log().Info("Bought cargo", "action", "purchase_cargo", "trade_symbol", tradeSymbol, "units", units, "credits_delta", resp.Data.Agent.Credits-previous.Credits)
+	return nil
+}
+
+// Jettison dumps cargo, e.g. goods which no marketplace will buy.
+func (as *AugmentedShip) Jettison(ctx context.Context, tradeSymbol string, units int32) error {
+	resp, _, err := as.app.client.FleetApi.Jettison(ctx, as.shipID).JettisonRequest(api.JettisonRequest{
+		Symbol: tradeSymbol,
+		Units:  units,
+	}).Execute()
+	e := journal.Entry{
+		Action:  "jettison",
+		Request: map[string]any{"trade_symbol": tradeSymbol, "units": units},
+	}
 	if err != nil {
+		err = decodeAPIError(err)
+		as.record(e, err)
 		return err
 	}
-	ship := as.app.ships[as.shipID]
-	ship.Cargo = resp.Data.Cargo
-	as.app.ships[as.shipID] = ship
-	as.app.agent = resp.Data.Agent
+	as.record(e, nil)
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Cargo = resp.Data.Cargo
+	})
 	return nil
 }
 
@@ -163,45 +294,82 @@ func (as *AugmentedShip) Extract(ctx context.Context, symbol string) (time.Time,
 	if symbol != "" {
 		req.Survey = as.app.getSurvey(as.Ship().Nav.WaypointSymbol, symbol)
 		if req.Survey != nil {
-			fmt.Printf("Using survey: %s\n", formatSurvey(*req.Survey))
+			as.log().Debug("Using survey", "survey", req.Survey.Signature, "deposits", formatSurvey(*req.Survey))
 		}
 	}
-	resp, httpResp, err := as.app.client.FleetApi.ExtractResources(ctx, as.shipID).ExtractResourcesRequest(req).Execute()
+	resp, _, err := as.app.client.FleetApi.ExtractResources(ctx, as.shipID).ExtractResourcesRequest(req).Execute()
+	e := journal.Entry{Action: "extract", Request: map[string]any{"target": symbol}}
+	if req.Survey != nil {
+		e.Request["survey"] = req.Survey.Signature
+	}
 	if err != nil {
-		if httpResp.StatusCode == 409 {
-			apiError, err := getAPIError(err)
-			if err != nil {
-				return time.Time{}, err
-			}
-			if apiError.Code != 4000 {
-				return time.Time{}, fmt.Errorf("Unexpected 409 response: %d - %s", apiError.Code, apiError.Message)
+		err = decodeAPIError(err)
+		as.record(e, err)
+		var cooldownErr *CooldownError
+		var surveyExhaustedErr *SurveyExhaustedError
+		var surveyExpiredErr *SurveyExpiredError
+		var cargoFullErr *CargoFullError
+		var notInOrbitErr *ShipNotInOrbitError
+		switch {
+		case errors.As(err, &cooldownErr):
+			as.log().Info("Still on cooldown", "action", "extract", "remaining_seconds", cooldownErr.Cooldown.RemainingSeconds, "total_seconds", cooldownErr.Cooldown.TotalSeconds)
+			return cooldownErr.Cooldown.GetExpiration(), nil
+		case errors.As(err, &surveyExhaustedErr), errors.As(err, &surveyExpiredErr):
+			// Forget the survey; the next round will use a different one or survey again.
+			if req.Survey != nil {
+				as.log().Info("Discarding unusable survey", "action", "extract", "survey", req.Survey.Signature, "error", err)
+				return time.Time{}, as.app.removeSurvey(as.Ship().Nav.WaypointSymbol, req.Survey.Signature)
 			}
-			cd := &api.Cooldown{}
-			if err := apiError.decodeData("cooldown", cd); err != nil {
-				return time.Time{}, err
-			}
-			fmt.Printf("Still on cooldown; %d of %d seconds remaining\n", cd.RemainingSeconds, cd.TotalSeconds)
-			return cd.GetExpiration(), nil
+		case errors.As(err, &cargoFullErr), errors.As(err, &notInOrbitErr):
+			// Our view of the ship is out of date; refresh it so the next round can decide what to do.
+			return time.Time{}, as.Refresh(ctx)
 		}
 		return time.Time{}, err
 	}
-	ship := as.app.ships[as.shipID]
-	ship.Cargo = resp.Data.Cargo
-	as.app.ships[as.shipID] = ship
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Cargo = resp.Data.Cargo
+	})
+	yield := resp.Data.Extraction.Yield
+	e.Response = map[string]any{"trade_symbol": yield.Symbol, "units": yield.Units}
+	as.record(e, nil)
+	as.log().Info("Extracted resources", "action", "extract", "trade_symbol", yield.Symbol, "units", yield.Units)
+	extractions.Inc(yield.Symbol)
+	extractedUnits.Add(float64(yield.Units), yield.Symbol)
 	return resp.Data.Cooldown.GetExpiration(), nil
 }
 
 func (as *AugmentedShip) TravelTo(ctx context.Context, waypoint string) (time.Time, error) {
+	if as.Ship().Nav.Status == api.SHIPNAVSTATUS_DOCKED {
+		if err := as.Orbit(ctx); err != nil {
+			return time.Time{}, err
+		}
+	}
 	resp, _, err := as.app.client.FleetApi.NavigateShip(ctx, as.shipID).NavigateShipRequest(api.NavigateShipRequest{
 		WaypointSymbol: waypoint,
 	}).Execute()
+	e := journal.Entry{Action: "navigate", Request: map[string]any{"destination": waypoint}}
 	if err != nil {
+		err = decodeAPIError(err)
+		as.record(e, err)
+		var sameDestinationErr *SameDestinationError
+		var inTransitErr *ShipInTransitError
+		if errors.As(err, &sameDestinationErr) || errors.As(err, &inTransitErr) {
+			if err := as.Refresh(ctx); err != nil {
+				return time.Time{}, err
+			}
+			if as.Ship().Nav.Status == api.SHIPNAVSTATUS_IN_TRANSIT {
+				return as.Ship().Nav.Route.Arrival, nil
+			}
+			return time.Time{}, nil
+		}
 		return time.Time{}, err
 	}
-	ship := as.app.ships[as.shipID]
-	ship.Nav = resp.Data.Nav
-	ship.Fuel = resp.Data.Fuel
-	as.app.ships[as.shipID] = ship
+	e.Response = map[string]any{"arrival": resp.Data.Nav.Route.Arrival, "fuel": resp.Data.Fuel.Current}
+	as.record(e, nil)
+	as.app.updateShip(as.shipID, func(ship *api.Ship) {
+		ship.Nav = resp.Data.Nav
+		ship.Fuel = resp.Data.Fuel
+	})
 	return resp.Data.Nav.Route.Arrival, nil
 }
 
@@ -211,27 +379,27 @@ func (as *AugmentedShip) Survey(ctx context.Context) (time.Time, error) {
 			return time.Time{}, err
 		}
 	}
-	resp, httpResp, err := as.app.client.FleetApi.CreateSurvey(ctx, as.shipID).Execute()
+	resp, _, err := as.app.client.FleetApi.CreateSurvey(ctx, as.shipID).Execute()
 	if err != nil {
-		if httpResp.StatusCode == 409 {
-			apiError, err := getAPIError(err)
-			if err != nil {
-				return time.Time{}, err
-			}
-			if apiError.Code != 4000 {
-				return time.Time{}, fmt.Errorf("Unexpected 409 response: %d - %s", apiError.Code, apiError.Message)
-			}
-			cd := &api.Cooldown{}
-			if err := apiError.decodeData("cooldown", cd); err != nil {
-				return time.Time{}, err
-			}
-			fmt.Printf("Still on cooldown; %d of %d seconds remaining\n", cd.RemainingSeconds, cd.TotalSeconds)
-			return cd.GetExpiration(), nil
+		err = decodeAPIError(err)
+		as.record(journal.Entry{Action: "survey"}, err)
+		var cooldownErr *CooldownError
+		if errors.As(err, &cooldownErr) {
+			as.log().Info("Still on cooldown", "action", "survey", "remaining_seconds", cooldownErr.Cooldown.RemainingSeconds, "total_seconds", cooldownErr.Cooldown.TotalSeconds)
+			return cooldownErr.Cooldown.GetExpiration(), nil
 		}
 		return time.Time{}, err
 	}
 
-	as.app.setSurveys(as.Ship().Nav.WaypointSymbol, resp.Data.Surveys)
+	surveysCreated.Add(float64(len(resp.Data.Surveys)))
+	var signatures []string
+	for _, survey := range resp.Data.Surveys {
+		signatures = append(signatures, survey.Signature)
+	}
+	as.record(journal.Entry{Action: "survey", Response: map[string]any{"surveys": signatures}}, nil)
+	if err := as.app.setSurveys(as.Ship().Nav.WaypointSymbol, resp.Data.Surveys); err != nil {
+		return time.Time{}, err
+	}
 
 	return resp.Data.Cooldown.GetExpiration(), nil
 }
@@ -0,0 +1,24 @@
+package app
+
+// Strategy holds the tunable parameters of the activity loop, which are saved as the configuration
+// of the procurement behavior.
+type Strategy struct {
+	// SurveyThreshold is the minimum fraction of a survey's deposits which must be a wanted material
+	// for the survey to be used; below this, ships which can survey will do so before extracting.
+	SurveyThreshold float64 `json:"surveyThreshold"`
+	// ExtractCargoThreshold is the fraction of the cargo hold above which ships stop extracting and
+	// go to deliver or sell what they have.
+	ExtractCargoThreshold float64 `json:"extractCargoThreshold"`
+}
+
+func DefaultStrategy() Strategy {
+	return Strategy{
+		SurveyThreshold:       0.2,
+		ExtractCargoThreshold: 0.85,
+	}
+}
+
+// SetStrategy replaces the default strategy used by the activity loop.
+func (a *App) SetStrategy(s Strategy) {
+	a.strategy = s
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+// transportBehavior works transport and shuttle contracts. Their terms only say what to deliver and
+// where, so the ship buys the goods at the nearest marketplace which sells them, carries them to
+// the destination and delivers them, then fulfils the contract.
+type transportBehavior struct {
+	app *App
+}
+
+func (b transportBehavior) Name() string {
+	return "transport"
+}
+
+func (b transportBehavior) Description() string {
+	return "buy the goods for a transport or shuttle contract at the nearest marketplace and deliver them"
+}
+
+func (b transportBehavior) Config() any {
+	return nil
+}
+
+func (b transportBehavior) Act(ctx context.Context, as *AugmentedShip) (time.Time, error) {
+	a := b.app
+	readyTime, err := a.checkShipTransit(ctx, as)
+	if err != nil || !readyTime.IsZero() {
+		return readyTime, err
+	}
+
+	waypointTraits, err := a.getCurrentWaypointTraits(ctx, as)
+	if err != nil {
+		return time.Time{}, err
+	}
+	here := as.Ship().Nav.WaypointSymbol
+
+	if waypointTraits["MARKETPLACE"] && as.Ship().Fuel.Current < as.Ship().Fuel.Capacity {
+		as.log().Info("Refuelling", "action", "refuel")
+		if err := as.TryRefuel(ctx); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	// Deliver whatever is due here.
+	for _, d := range as.Contract().Terms.Deliver {
+		units := min(d.UnitsRequired-d.UnitsFulfilled, cargoUnits(as.Ship().Cargo, d.TradeSymbol))
+		if d.DestinationSymbol != here || units <= 0 {
+			continue
+		}
+		as.log().Info("Delivering goods", "action", "deliver", "trade_symbol", d.TradeSymbol, "units", units)
+		if err := as.DeliverGoods(ctx, d.TradeSymbol, units); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	// Work out what is still to be delivered and where, and what has to be bought first.
+	carried := map[string]int32{}
+	for _, c := range as.Ship().Cargo.Inventory {
+		carried[c.Symbol] = c.Units
+	}
+	contractGoods := map[string]bool{}
+	destinations := map[string]bool{}
+	toBuy := map[string]int32{}
+	for _, d := range as.Contract().Terms.Deliver {
+		contractGoods[d.TradeSymbol] = true
+		remaining := d.UnitsRequired - d.UnitsFulfilled
+		if remaining <= 0 {
+			continue
+		}
+		if units := min(remaining, carried[d.TradeSymbol]); units > 0 {
+			destinations[d.DestinationSymbol] = true
+			carried[d.TradeSymbol] -= units
+			remaining -= units
+		}
+		if remaining > 0 {
+			toBuy[d.TradeSymbol] += remaining
+		}
+	}
+	if len(destinations) == 0 && len(toBuy) == 0 {
+		if err := a.fulfillContract(ctx, as.contractID); err != nil {
+			return time.Time{}, err
+		}
+		return time.Time{}, ErrContractFulfilled
+	}
+
+	// Make room for the contract's goods by selling, or failing that dumping, anything else.
+	surplus := func(symbol string) bool {
+		return symbol != "ANTIMATTER" && !contractGoods[symbol]
+	}
+	if waypointTraits["MARKETPLACE"] {
+		for _, c := range as.Ship().Cargo.Inventory {
+			if !surplus(c.Symbol) {
+				continue
+			}
+			as.log().Info("Selling unneeded cargo", "action", "sell", "trade_symbol", c.Symbol, "units", c.Units)
+			if err := as.SellCargo(ctx, c.Symbol, c.Units); err != nil {
+				var notSoldErr *TradeNotSoldError
+				if errors.As(err, &notSoldErr) {
+					as.log().Warn("Market does not buy cargo", "action", "sell", "trade_symbol", c.Symbol, "units", c.Units)
+					continue
+				}
+				return time.Time{}, err
+			}
+		}
+	}
+	if err := a.jettisonUnsellable(ctx, as, surplus); err != nil {
+		return time.Time{}, err
+	}
+
+	if waypointTraits["MARKETPLACE"] && len(toBuy) > 0 {
+		bought, err := b.buy(ctx, as, toBuy)
+		if err != nil || bought {
+			return time.Time{}, err
+		}
+	}
+
+	// Buy the rest before setting off, unless the hold is full.
+	ship := as.Ship()
+	if len(toBuy) > 0 && ship.Cargo.Units < ship.Cargo.Capacity {
+		for _, symbol := range sortedKeys(toBuy) {
+			market, err := a.nearestSupplier(ctx, as, symbol)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if market == "" || market == here {
+				continue
+			}
+			as.log().Info("Travelling to buy goods", "action", "navigate", "destination", market, "trade_symbol", symbol)
+			return as.TravelTo(ctx, market)
+		}
+	}
+
+	for _, destination := range sortedKeys(destinations) {
+		as.log().Info("Travelling to deliver goods", "action", "navigate", "destination", destination)
+		return as.TravelTo(ctx, destination)
+	}
+
+	if ship.Cargo.Units >= ship.Cargo.Capacity {
+		market, err := a.bestMarket(ctx, as, surplus)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if market != "" && market != here {
+			as.log().Info("Travelling to sell unneeded cargo", "action", "navigate", "destination", market)
+			return as.TravelTo(ctx, market)
+		}
+		return time.Time{}, fmt.Errorf("%w: cargo hold is full of goods which can't be sold", ErrShipIdle)
+	}
+	return time.Time{}, fmt.Errorf("%w: no marketplace within range sells %v", ErrShipIdle, sortedKeys(toBuy))
+}
+
+// buy purchases as much of the goods still to be bought as the marketplace the ship is at sells and
+// the hold has room for, reporting whether it bought anything.
+func (b transportBehavior) buy(ctx context.Context, as *AugmentedShip, toBuy map[string]int32) (bool, error) {
+	ship := as.Ship()
+	market, err := b.app.getMarket(ctx, ship.Nav.SystemSymbol, ship.Nav.WaypointSymbol)
+	if err != nil {
+		return false, err
+	}
+	bought := false
+	for _, good := range market.TradeGoods {
+		units := min(toBuy[good.Symbol], as.Ship().Cargo.Capacity-as.Ship().Cargo.Units)
+		for units > 0 {
+			batch := units
+			if good.TradeVolume > 0 {
+				batch = min(batch, good.TradeVolume)
+			}
+			as.log().Info("Buying goods", "action", "purchase_cargo", "trade_symbol", good.Symbol, "units", batch)
+			if err := as.PurchaseCargo(ctx, good.Symbol, batch); err != nil {
+				var creditsErr *InsufficientCreditsError
+				if errors.As(err, &creditsErr) {
+					return bought, fmt.Errorf("%w: can't afford %d units of %s", ErrShipIdle, batch, good.Symbol)
+				}
+				return bought, err
+			}
+			bought = true
+			units -= batch
+		}
+	}
+	return bought, nil
+}
+
+// cargoUnits returns the number of units of a good in a cargo hold.
+func cargoUnits(cargo api.ShipCargo, tradeSymbol string) int32 {
+	for _, c := range cargo.Inventory {
+		if c.Symbol == tradeSymbol {
+			return c.Units
+		}
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"testing"
+
+	"fivebit.co.uk/spacetraders/fakeserver"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+// TestTransportContract runs transport and shuttle contracts with the command ship, which has to
+// buy the goods and carry them to the destination: first buying where it starts, then travelling
+// to buy them.
+func TestTransportContract(t *testing.T) {
+	for _, tc := range []struct {
+		contractType string
+		tradeSymbol  string
+		destination  string
+	}{
+		{"TRANSPORT", "COPPER_ORE", fakeserver.TradingPost},
+		{"SHUTTLE", "PRECIOUS_STONES", fakeserver.Headquarters},
+	} {
+		t.Run(tc.contractType, func(t *testing.T) {
+			ctx, a, srv, clk, reg := testApp(t)
+			cID := srv.AddContractOfType("TESTER", tc.contractType, tc.tradeSymbol, tc.destination, 40)
+			if _, _, err := a.client.ContractsApi.AcceptContract(ctx, cID).Execute(); err != nil {
+				t.Fatalf("accepting contract: %v", err)
+			}
+			shipID := reg.Ship.Symbol
+			if err := a.state.Update(func(ms state.MutableState) error {
+				ms.AssignShip(cID, shipID)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.loadContracts(ctx); err != nil {
+				t.Fatal(err)
+			}
+			as := a.augmentShip(shipID)
+			if b := a.shipBehavior(as); b == nil || b.Name() != "transport" {
+				t.Fatalf("behavior for %s contract = %v, want transport", tc.contractType, b)
+			}
+
+			runUntilFulfilled(t, ctx, a, clk, as)
+
+			c, _ := srv.Contract(cID)
+			if !c.Fulfilled {
+				t.Errorf("server contract not fulfilled")
+			}
+			stats := srv.Stats()
+			if stats.Purchases == 0 || stats.Extractions != 0 {
+				t.Errorf("purchases = %d, extractions = %d; want goods bought rather than mined", stats.Purchases, stats.Extractions)
+			}
+			ship, _ := srv.Ship(shipID)
+			if n := cargoUnits(ship.Cargo, tc.tradeSymbol); n != 0 {
+				t.Errorf("%d units of %s left in cargo", n, tc.tradeSymbol)
+			}
+			agent, _ := srv.Agent("TESTER")
+			if a.agent.Credits != agent.Credits {
+				t.Errorf("credits = %d, server has %d", a.agent.Credits, agent.Credits)
+			}
+		})
+	}
+}
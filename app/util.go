@@ -3,6 +3,7 @@ package app
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"text/template"
 )
 
@@ -20,3 +21,13 @@ func stringTemplate(tpl *template.Template, data any) (string, error) {
 	err := tpl.Execute(&buf, data)
 	return buf.String(), err
 }
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
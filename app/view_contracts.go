@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"text/template"
 
+	"fivebit.co.uk/spacetraders/journal"
 	"fivebit.co.uk/spacetraders/prompt"
 	"fivebit.co.uk/spacetraders/state"
 )
@@ -36,7 +37,7 @@ func viewContracts(ctx context.Context, app *App) error {
 func listContracts(ctx context.Context, app *App, page int32) error {
 	resp, _, err := app.client.ContractsApi.GetContracts(ctx).Page(page).Limit(10).Execute()
 	if err != nil {
-		return err
+		return decodeAPIError(err)
 	}
 
 	var items []prompt.MenuItem
@@ -125,12 +126,32 @@ func viewContract(ctx context.Context, app *App, ac *AugmentedContract) error {
 }
 
 func acceptAndAssign(ctx context.Context, app *App, ac *AugmentedContract) error {
+	if !app.handlesAssignment(ac.Contract.Type) {
+		fmt.Printf("No behavior can work on %s contracts, so ships assigned to this one will stay idle\n", ac.Contract.Type)
+		ok, err := prompt.Confirm("Accept anyway")
+		if err != nil || !ok {
+			return err
+		}
+	}
 	resp, _, err := app.client.ContractsApi.AcceptContract(ctx, ac.Contract.Id).Execute()
 	if err != nil {
+		err = decodeAPIError(err)
+		app.record(journal.Entry{Action: "accept_contract", Contract: ac.Contract.Id}, err)
+		var deadlineErr *ContractDeadlineError
+		if errors.As(err, &deadlineErr) {
+			fmt.Printf("Contract can no longer be accepted: %s\n", deadlineErr.Message)
+			return nil
+		}
 		return err
 	}
+	previous := app.setAgent(resp.Data.Agent)
+	app.record(journal.Entry{
+		Action:   "accept_contract",
+		Contract: ac.Contract.Id,
+		Response: map[string]any{"credits_delta": resp.Data.Agent.Credits - previous.Credits},
+	}, nil)
 	ac.Contract = resp.Data.Contract
-	app.agent = resp.Data.Agent
+	app.addContract(ac.Contract)
 	return assignShips(ctx, app, ac)
 }
 
@@ -171,9 +192,18 @@ func assignShip(ctx context.Context, app *App, ac *AugmentedContract, as *Augmen
 	}); err != nil {
 		return err
 	}
+	// The contract may have been accepted earlier, before any ships were assigned to it.
+	if _, ok := app.getContract(ac.Contract.Id); !ok {
+		app.addContract(ac.Contract)
+	}
+	app.wakeShips(as.shipID)
 	ac.Ships = append(ac.Ships, as.Ship())
 	as.contractID = ac.Contract.Id
 	_, err := app.shipActivity(ctx, as)
+	if errors.Is(err, ErrShipIdle) {
+		fmt.Printf("%s is idle: %v\n", as.shipID, err)
+		return nil
+	}
 	return err
 }
 
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"testing"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+// TestAssignShipActivatesContract checks that assigning a ship to a contract which was accepted
+// without any ships makes it active, so that the ship starts working on it.
+func TestAssignShipActivatesContract(t *testing.T) {
+	ctx, a, _, _, reg := testApp(t)
+	resp, _, err := a.client.ContractsApi.AcceptContract(ctx, reg.Contract.Id).Execute()
+	if err != nil {
+		t.Fatalf("accepting contract: %v", err)
+	}
+
+	as := a.augmentShip(reg.Ship.Symbol)
+	if err := assignShip(ctx, a, a.augmentContract(resp.Data.Contract), as); err != nil {
+		t.Fatalf("assigning ship: %v", err)
+	}
+	if _, ok := a.getContract(reg.Contract.Id); !ok {
+		t.Errorf("contract not active after assigning a ship")
+	}
+	if c := as.Contract(); c == nil || c.Id != reg.Contract.Id {
+		t.Errorf("ship's contract = %v, want %s", c, reg.Contract.Id)
+	}
+	// The ship's first move is to head for the asteroid field.
+	if status := as.Ship().Nav.Status; status != api.SHIPNAVSTATUS_IN_TRANSIT {
+		t.Errorf("ship is %s after assigning, want IN_TRANSIT", status)
+	}
+}
@@ -54,6 +54,16 @@ func viewShip(ctx context.Context, app *App, as *AugmentedShip) error {
 				},
 			})
 		}
+		label := "Change behavior"
+		if b := app.shipBehavior(as); b != nil {
+			label += fmt.Sprintf(" (currently %s)", b.Name())
+		}
+		items = append(items, prompt.MenuItem{
+			Label: label,
+			Fn: func() error {
+				return changeBehavior(ctx, app, as)
+			},
+		})
 		items = append(items, prompt.MenuItem{
 			Label: "View raw",
 			Fn: func() error {
@@ -23,7 +23,7 @@ func viewWaypoint(ctx context.Context, app *App) error {
 
 	resp, _, err := app.client.SystemsApi.GetWaypoint(ctx, wp.system.String(), wp.String()).Execute()
 	if err != nil {
-		return err
+		return decodeAPIError(err)
 	}
 
 	return printTemplate(waypointFullTemplate, resp.Data)
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+var tokenRegexp = regexp.MustCompile(`("token"\s*:\s*)"[^"]*"`)
+
+// Cassette is an ordered recording of requests made to the API and the responses received.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(bs, c); err != nil {
+		return nil, fmt.Errorf("reading cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	bs, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, bs, 0600)
+}
+
+// recordingTransport passes requests through to the next transport, saving every interaction to
+// the cassette file as it happens, with the agent token redacted.
+type recordingTransport struct {
+	mu       sync.Mutex
+	path     string
+	cassette *Cassette
+	next     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	reqHeader := req.Header.Clone()
+	if reqHeader.Get("Authorization") != "" {
+		reqHeader.Set("Authorization", "Bearer "+redacted)
+	}
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: reqHeader,
+			Body:   redactTokens(string(reqBody)),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			Body:       redactTokens(string(respBody)),
+		},
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cassette.Interactions = append(t.cassette.Interactions, interaction)
+	if err := t.cassette.Save(t.path); err != nil {
+		return nil, fmt.Errorf("saving cassette: %w", err)
+	}
+	return resp, nil
+}
+
+func redactTokens(body string) string {
+	return tokenRegexp.ReplaceAllString(body, `$1"`+redacted+`"`)
+}
+
+// ReplayTransport serves responses from a cassette instead of making requests. Requests are matched
+// on method, path and query parameters (in any order), preferring interactions in the order they
+// were recorded.
+//
+// In strict mode every request must match a distinct recorded interaction with the same body, and
+// any other request fails. Otherwise, requests which have no unused match are served the most
+// recently used matching response, so repeated reads don't need to be recorded repeatedly.
+type ReplayTransport struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	strict   bool
+	used     []bool
+	lastUsed map[string]int
+}
+
+func NewReplayTransport(c *Cassette, strict bool) *ReplayTransport {
+	return &ReplayTransport{
+		cassette: c,
+		strict:   strict,
+		used:     make([]bool, len(c.Interactions)),
+		lastUsed: map[string]int{},
+	}
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	key := matchKey(req.Method, req.URL)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, interaction := range t.cassette.Interactions {
+		if t.used[i] {
+			continue
+		}
+		u, err := url.Parse(interaction.Request.URL)
+		if err != nil {
+			return nil, err
+		}
+		if matchKey(interaction.Request.Method, u) != key {
+			continue
+		}
+		if t.strict && !sameBody(interaction.Request.Body, string(reqBody)) {
+			continue
+		}
+		t.used[i] = true
+		t.lastUsed[key] = i
+		return interaction.Response.toHTTP(req), nil
+	}
+	if i, ok := t.lastUsed[key]; ok && !t.strict {
+		return t.cassette.Interactions[i].Response.toHTTP(req), nil
+	}
+	return nil, fmt.Errorf("no recorded interaction for %s %s", req.Method, req.URL)
+}
+
+// Unused returns the recorded interactions which have not yet been replayed.
+func (t *ReplayTransport) Unused() []*Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var unused []*Interaction
+	for i, interaction := range t.cassette.Interactions {
+		if !t.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+func (rr RecordedResponse) toHTTP(req *http.Request) *http.Response {
+	header := rr.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rr.StatusCode, http.StatusText(rr.StatusCode)),
+		StatusCode:    rr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(rr.Body)),
+		ContentLength: int64(len(rr.Body)),
+		Request:       req,
+	}
+}
+
+func matchKey(method string, u *url.URL) string {
+	query := u.Query()
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	// Encode sorts by key
+	return fmt.Sprintf("%s %s?%s", method, u.Path, query.Encode())
+}
+
+// sameBody compares request bodies, ignoring formatting differences if both are JSON.
+func sameBody(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var aVal, bVal any
+	if json.Unmarshal([]byte(a), &aVal) != nil || json.Unmarshal([]byte(b), &bVal) != nil {
+		return false
+	}
+	aBytes, _ := json.Marshal(aVal)
+	bBytes, _ := json.Marshal(bVal)
+	return bytes.Equal(aBytes, bBytes)
+}
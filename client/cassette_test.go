@@ -0,0 +1,119 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/client"
+	"fivebit.co.uk/spacetraders/config"
+	"fivebit.co.uk/spacetraders/fakeserver"
+)
+
+// recordSession registers an agent with a fake server and makes a few reads, recording them to a
+// cassette at path. It returns the agent's token and headquarters system.
+func recordSession(t *testing.T, path string) (string, string) {
+	t.Helper()
+	srv := fakeserver.Start()
+	defer srv.Close()
+	c, err := client.New(client.WithConfig(config.Config{ServerURL: srv.URL()}), client.WithRecording(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	reg, _, err := c.DefaultApi.Register(ctx).RegisterRequest(api.RegisterRequest{Symbol: "RECORDER", Faction: "COSMIC"}).Execute()
+	if err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+	ctx = context.WithValue(ctx, api.ContextAccessToken, reg.Data.Token)
+	if _, _, err := c.AgentsApi.GetMyAgent(ctx).Execute(); err != nil {
+		t.Fatalf("getting agent: %v", err)
+	}
+	system := reg.Data.Ship.Nav.SystemSymbol
+	if _, _, err := c.SystemsApi.GetSystemWaypoints(ctx, system).Page(1).Limit(20).Execute(); err != nil {
+		t.Fatalf("getting waypoints: %v", err)
+	}
+	return reg.Data.Token, system
+}
+
+func TestCassetteRedactsTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	token, _ := recordSession(t, path)
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), token) {
+		t.Errorf("cassette contains the agent token")
+	}
+	c, err := client.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Interactions) != 3 {
+		t.Fatalf("recorded %d interactions, want 3", len(c.Interactions))
+	}
+	if !strings.Contains(c.Interactions[0].Response.Body, `"token":"REDACTED"`) {
+		t.Errorf("registration response token not redacted: %s", c.Interactions[0].Response.Body)
+	}
+	for _, interaction := range c.Interactions[1:] {
+		if got := interaction.Request.Header.Get("Authorization"); got != "Bearer REDACTED" {
+			t.Errorf("%s: Authorization header = %q, want redacted", interaction.Request.URL, got)
+		}
+	}
+}
+
+func TestCassetteReplaysStrictly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	_, system := recordSession(t, path)
+	cassette, err := client.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := client.NewReplayTransport(cassette, true)
+	cfg := api.NewConfiguration()
+	cfg.Servers = api.ServerConfigurations{{URL: "http://replay.invalid"}}
+	cfg.HTTPClient = &http.Client{Transport: replay}
+	c := api.NewAPIClient(cfg)
+
+	ctx := context.Background()
+	reg, _, err := c.DefaultApi.Register(ctx).RegisterRequest(api.RegisterRequest{Symbol: "RECORDER", Faction: "COSMIC"}).Execute()
+	if err != nil {
+		t.Fatalf("replaying registration: %v", err)
+	}
+	ctx = context.WithValue(ctx, api.ContextAccessToken, reg.Data.Token)
+	agent, _, err := c.AgentsApi.GetMyAgent(ctx).Execute()
+	if err != nil {
+		t.Fatalf("replaying agent: %v", err)
+	}
+	if agent.Data.Symbol != "RECORDER" {
+		t.Errorf("replayed agent %s, want RECORDER", agent.Data.Symbol)
+	}
+
+	// Query parameters match in any order; the client sent them as limit=20&page=1.
+	u := &url.URL{Scheme: "http", Host: "replay.invalid", Path: "/systems/" + system + "/waypoints", RawQuery: "page=1&limit=20"}
+	resp, err := (&http.Client{Transport: replay}).Get(u.String())
+	if err != nil {
+		t.Fatalf("replaying waypoints with reordered query: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), system) {
+		t.Errorf("replayed waypoints: HTTP %d %s", resp.StatusCode, body)
+	}
+
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Errorf("%d interactions were not replayed", len(unused))
+	}
+	// In strict mode each interaction is only replayed once.
+	if _, _, err := c.AgentsApi.GetMyAgent(ctx).Execute(); err == nil {
+		t.Errorf("repeated request was replayed in strict mode")
+	}
+}
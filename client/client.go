@@ -0,0 +1,178 @@
+// Package client builds the SpaceTraders API client shared by the rest of the application. All
+// requests go through a single http.Client whose transport retries transient failures and enforces
+// the server's rate limits, recording metrics for every request.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/config"
+)
+
+type options struct {
+	config       config.Config
+	recordPath   string
+	replayPath   string
+	replayStrict bool
+}
+
+type Option func(*options)
+
+// WithConfig sets the server URL, user agent, timeouts, proxy and TLS settings.
+func WithConfig(cfg config.Config) Option {
+	return func(o *options) {
+		o.config = cfg
+	}
+}
+
+// WithRecording saves every request and response to a cassette file at path.
+func WithRecording(path string) Option {
+	return func(o *options) {
+		o.recordPath = path
+	}
+}
+
+// WithReplay serves responses from the cassette file at path instead of contacting the server.
+func WithReplay(path string, strict bool) Option {
+	return func(o *options) {
+		o.replayPath = path
+		o.replayStrict = strict
+	}
+}
+
+func New(opts ...Option) (*api.APIClient, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var transport http.RoundTripper
+	if o.replayPath != "" {
+		cassette, err := LoadCassette(o.replayPath)
+		if err != nil {
+			return nil, err
+		}
+		// Replayed responses don't count against the server's rate limits.
+		transport = &metricsTransport{next: NewReplayTransport(cassette, o.replayStrict)}
+	} else {
+		base, err := baseTransport(o.config)
+		if err != nil {
+			return nil, err
+		}
+		// The request timeout applies to each attempt, excluding retry backoff and rate limit waits.
+		transport = &metricsTransport{
+			next: &timeoutTransport{timeout: time.Duration(o.config.Timeouts.Request), next: base},
+		}
+		if o.recordPath != "" {
+			transport = &recordingTransport{
+				path:     o.recordPath,
+				cassette: &Cassette{},
+				next:     transport,
+			}
+		}
+		transport = &rateLimitedTransport{
+			limiter: NewRateLimiter(DefaultRatePerSecond, DefaultBurst),
+			next:    transport,
+		}
+	}
+
+	cfg := api.NewConfiguration()
+	if o.config.ServerURL != "" {
+		cfg.Servers = api.ServerConfigurations{{URL: strings.TrimSuffix(o.config.ServerURL, "/")}}
+	}
+	if o.config.UserAgent != "" {
+		cfg.UserAgent = o.config.UserAgent
+	}
+	cfg.HTTPClient = &http.Client{
+		Transport: &retryTransport{
+			maxRetries: defaultMaxRetries,
+			baseDelay:  defaultBaseDelay,
+			maxDelay:   defaultMaxDelay,
+			next:       transport,
+		},
+	}
+	return api.NewAPIClient(cfg), nil
+}
+
+// baseTransport returns the transport which actually makes requests, configured from cfg.
+func baseTransport(cfg config.Config) (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", cfg.ProxyURL, err)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+	if cfg.Timeouts.Dial > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   time.Duration(cfg.Timeouts.Dial),
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if cfg.Timeouts.TLSHandshake > 0 {
+		t.TLSHandshakeTimeout = time.Duration(cfg.Timeouts.TLSHandshake)
+	}
+	t.ResponseHeaderTimeout = time.Duration(cfg.Timeouts.ResponseHeader)
+	if cfg.TLS.CAFile != "" || cfg.TLS.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLS.InsecureSkipVerify}
+		if cfg.TLS.CAFile != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			pem, err := os.ReadFile(cfg.TLS.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.TLS.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+	return t, nil
+}
+
+// timeoutTransport limits how long each request may take, including reading the response body.
+type timeoutTransport struct {
+	timeout time.Duration
+	next    http.RoundTripper
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fivebit.co.uk/spacetraders/metrics"
+)
+
+var (
+	apiRequests = metrics.NewCounter(
+		"spacetraders_api_requests_total",
+		"API requests sent, by endpoint and response status (or \"error\" if no response was received).",
+		"method", "endpoint", "status")
+	apiLatency = metrics.NewHistogram(
+		"spacetraders_api_request_duration_seconds",
+		"Time taken for the server to respond to API requests, excluding rate limit waits.",
+		metrics.DefaultBuckets, "method", "endpoint")
+	apiRetries = metrics.NewCounter(
+		"spacetraders_api_retries_total",
+		"API requests repeated after a transient failure, by endpoint.",
+		"method", "endpoint")
+	rateLimitWaits = metrics.NewHistogram(
+		"spacetraders_rate_limit_wait_seconds",
+		"Time requests spent waiting for the client-side rate limiter.",
+		[]float64{0, .1, .25, .5, 1, 2.5, 5, 10, 30, 60})
+)
+
+// Path segments which are followed by an identifier, which is replaced by a placeholder so that
+// requests for different ships, contracts etc. share an endpoint label.
+var pathParams = map[string]string{
+	"agents":    "{agentSymbol}",
+	"contracts": "{contractId}",
+	"factions":  "{factionSymbol}",
+	"ships":     "{shipSymbol}",
+	"systems":   "{systemSymbol}",
+	"waypoints": "{waypointSymbol}",
+}
+
+// endpoint returns the path of an API request with identifiers replaced by placeholders, e.g.
+// /my/ships/{shipSymbol}/extract.
+func endpoint(req *http.Request) string {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) > 0 && parts[0] == "v2" {
+		parts = parts[1:]
+	}
+	for i := 1; i < len(parts); i++ {
+		if param, ok := pathParams[parts[i-1]]; ok {
+			parts[i] = param
+			i++
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// metricsTransport records the outcome and latency of every request which reaches the network (or
+// the cassette being replayed).
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ep := endpoint(req)
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	apiLatency.Observe(time.Since(start).Seconds(), req.Method, ep)
+	if err != nil {
+		apiRequests.Inc(req.Method, ep, "error")
+		return nil, err
+	}
+	apiRequests.Inc(req.Method, ep, strconv.Itoa(resp.StatusCode))
+	return resp, nil
+}
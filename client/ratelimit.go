@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Documented SpaceTraders limits: 2 requests per second, with a burst pool of 10 requests.
+const (
+	DefaultRatePerSecond = 2.0
+	DefaultBurst         = 10
+)
+
+// RateLimiter is a token bucket shared by every request made through the client. Tokens refill at
+// a steady rate up to the burst size; callers block until a token is available. The limits are
+// adjusted from the rate limit headers returned by the server.
+type RateLimiter struct {
+	mu          sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func NewRateLimiter(ratePerSecond float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:   ratePerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a request may be made, returning how long it waited.
+func (rl *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	wait := rl.reserve()
+	if wait <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		rl.cancel()
+		return 0, ctx.Err()
+	case <-timer.C:
+		return wait, nil
+	}
+}
+
+// reserve takes a token, possibly going into debt, and returns how long the caller must wait for
+// the token to become valid.
+func (rl *RateLimiter) reserve() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.refill(now)
+	rl.tokens--
+	var wait time.Duration
+	if rl.tokens < 0 {
+		wait = time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+	}
+	if paused := rl.pausedUntil.Sub(now); paused > wait {
+		wait = paused
+	}
+	return wait
+}
+
+func (rl *RateLimiter) cancel() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.tokens = math.Min(rl.tokens+1, rl.burst)
+}
+
+func (rl *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(rl.last).Seconds()
+	if elapsed > 0 {
+		rl.tokens = math.Min(rl.tokens+elapsed*rl.rate, rl.burst)
+		rl.last = now
+	}
+}
+
+// PauseUntil blocks all callers until the given time, e.g. after the server returns a 429.
+func (rl *RateLimiter) PauseUntil(t time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if t.After(rl.pausedUntil) {
+		rl.pausedUntil = t
+	}
+}
+
+// Observe adjusts the limiter based on the rate limit headers of a response.
+func (rl *RateLimiter) Observe(resp *http.Response) {
+	now := time.Now()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			rl.PauseUntil(now.Add(retryAfter))
+		}
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.refill(now)
+	if perSecond, err := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Limit-Per-Second"), 64); err == nil && perSecond > 0 {
+		rl.rate = perSecond
+	}
+	if burst, err := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Limit-Burst"), 64); err == nil && burst > 0 {
+		rl.burst = burst
+		rl.tokens = math.Min(rl.tokens, rl.burst)
+	}
+	remaining, err := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+	// The server knows better than we do how many requests we have left, e.g. if another client is
+	// using the same IP address.
+	if remaining < rl.tokens {
+		rl.tokens = remaining
+	}
+	if remaining <= 0 {
+		if reset, err := time.Parse(time.RFC3339, resp.Header.Get("X-Ratelimit-Reset")); err == nil && reset.After(rl.pausedUntil) {
+			rl.pausedUntil = reset
+		}
+	}
+}
+
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+type rateLimitedTransport struct {
+	limiter *RateLimiter
+	next    http.RoundTripper
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait, err := t.limiter.Wait(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	rateLimitWaits.Observe(wait.Seconds())
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.limiter.Observe(resp)
+	return resp, nil
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 5
+	defaultBaseDelay  = 500 * time.Millisecond
+	defaultMaxDelay   = 30 * time.Second
+)
+
+// Mutations which can safely be repeated if we don't know whether the first attempt reached the
+// server, because repeating them has no further effect. Extracting and surveying are not included:
+// if the first attempt was processed, its result would be lost and the retry would fail with a
+// cooldown error. Nor is refuelling, since a repeat could buy fuel twice. Other mutations are still
+// retried when they can't have reached the server, e.g. when the connection was refused.
+var safeMutationSuffixes = []string{
+	"/dock",
+	"/orbit",
+	"/chart",
+}
+
+// TransientError is returned when a request failed with a retryable error and either ran out of
+// retries or could not safely be retried.
+type TransientError struct {
+	Retries    int
+	StatusCode int
+	Err        error
+}
+
+func (e *TransientError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("transient error after %d retries: %v", e.Retries, e.Err)
+	}
+	return fmt.Sprintf("transient error after %d retries: HTTP %d", e.Retries, e.StatusCode)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+type retryTransport struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	next       http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for retries := 0; ; retries++ {
+		attempt := req
+		if retries > 0 {
+			var err error
+			if attempt, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.next.RoundTrip(attempt)
+
+		retryable, processed := classify(resp, err)
+		if !retryable {
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+		if retries >= t.maxRetries || (processed && !safeToRepeat(req)) {
+			statusCode := 0
+			if resp != nil {
+				statusCode = resp.StatusCode
+				drain(resp)
+			}
+			return nil, &TransientError{Retries: retries, StatusCode: statusCode, Err: err}
+		}
+		if resp != nil {
+			drain(resp)
+		}
+		apiRetries.Inc(req.Method, endpoint(req))
+
+		timer := time.NewTimer(t.backoff(retries))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns a delay with full jitter, growing exponentially with the number of retries.
+func (t *retryTransport) backoff(retries int) time.Duration {
+	delay := t.maxDelay
+	if retries < 32 && t.baseDelay<<retries < t.maxDelay {
+		delay = t.baseDelay << retries
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// classify reports whether a request should be retried, and whether the server may have processed
+// it (in which case it can only be retried if repeating it is safe).
+func classify(resp *http.Response, err error) (retryable bool, processed bool) {
+	if err != nil {
+		var netErr net.Error
+		var opErr *net.OpError
+		switch {
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			return true, false
+		case errors.Is(err, syscall.ECONNREFUSED):
+			return true, false
+		case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+			return true, true
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return true, true
+		default:
+			return false, false
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true, false
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+func safeToRepeat(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		for _, suffix := range safeMutationSuffixes {
+			if strings.HasSuffix(req.URL.Path, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func rewind(req *http.Request) (*http.Request, error) {
+	attempt := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return attempt, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("cannot retry request with non-rewindable body")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	attempt.Body = body
+	return attempt, nil
+}
+
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
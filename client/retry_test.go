@@ -0,0 +1,59 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedTransport answers requests with a sequence of failures, then succeeds.
+type scriptedTransport struct {
+	failures []func() (*http.Response, error)
+	calls    int
+}
+
+func (t *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+	if t.calls <= len(t.failures) {
+		return t.failures[t.calls-1]()
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func badGateway() (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusBadGateway, Body: http.NoBody}, nil
+}
+
+func dialError() (*http.Response, error) {
+	return nil, &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+}
+
+func TestRetryRepeatsOnlySafeMutations(t *testing.T) {
+	for _, tc := range []struct {
+		path    string
+		name    string
+		failure func() (*http.Response, error)
+		retried bool
+	}{
+		{"/my/ships/S-1/orbit", "bad gateway", badGateway, true},
+		{"/my/ships/S-1/refuel", "bad gateway", badGateway, false},
+		{"/my/ships/S-1/extract", "bad gateway", badGateway, false},
+		// Requests which never reached the server are always safe to retry.
+		{"/my/ships/S-1/refuel", "dial error", dialError, true},
+	} {
+		next := &scriptedTransport{failures: []func() (*http.Response, error){tc.failure}}
+		rt := &retryTransport{maxRetries: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond, next: next}
+		req, err := http.NewRequest(http.MethodPost, "http://api.invalid"+tc.path, strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = rt.RoundTrip(req)
+		var transientErr *TransientError
+		if retried := !errors.As(err, &transientErr); retried != tc.retried || (retried && err != nil) {
+			t.Errorf("POST %s after %s: error %v, want retried %t", tc.path, tc.name, err, tc.retried)
+		}
+	}
+}
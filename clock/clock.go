@@ -0,0 +1,54 @@
+// Package clock abstracts the passage of time, so that code which waits for cooldowns and travel
+// can be run against a controllable clock.
+package clock
+
+import "time"
+
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+// Timer is the subset of time.Timer used by this project, with the channel behind a method so that
+// fake timers can be substituted.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Real returns a Clock backed by the time package.
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+func (t realTimer) Reset(d time.Duration) bool {
+	return t.t.Reset(d)
+}
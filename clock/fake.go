@@ -0,0 +1,157 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock which only moves when told to. Timers fire when the clock is moved to or past
+// their deadline.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]bool
+	// busy counts timers which have fired and not since been reset or stopped.
+	busy  int
+	armed chan struct{}
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:    now,
+		timers: map[*fakeTimer]bool{},
+		armed:  make(chan struct{}, 1),
+	}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	t := f.NewTimer(d)
+	<-t.C()
+	t.Stop()
+}
+
+// Advance moves the clock forward, firing any timers which become due.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to the given time, firing any timers which become due. Moving the clock
+// backwards is ignored.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if now.After(f.now) {
+		f.now = now
+	}
+	for t := range f.timers {
+		if !t.deadline.After(f.now) {
+			f.fire(t)
+		}
+	}
+}
+
+// NextDeadline returns the deadline of the earliest pending timer, if there is one.
+func (f *Fake) NextDeadline() (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var next time.Time
+	for t := range f.timers {
+		if next.IsZero() || t.deadline.Before(next) {
+			next = t.deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Idle reports whether every timer which has fired has since been reset or stopped, i.e. whether
+// everything waiting on the clock's timers has finished acting on them. Code which uses a timer
+// should reset or stop it once done with each firing, or the clock never becomes idle.
+func (f *Fake) Idle() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.busy == 0
+}
+
+// Armed receives a value whenever a timer is started, reset or stopped, so that whatever is driving
+// the clock can wait for the code under test to start waiting.
+func (f *Fake) Armed() <-chan struct{} {
+	return f.armed
+}
+
+// fire must be called with f.mu held.
+func (f *Fake) fire(t *fakeTimer) {
+	delete(f.timers, t)
+	if !t.fired {
+		t.fired = true
+		f.busy++
+	}
+	select {
+	case t.c <- f.now:
+	default:
+	}
+}
+
+type fakeTimer struct {
+	clock    *Fake
+	c        chan time.Time
+	deadline time.Time
+	fired    bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	pending := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	t.done()
+	t.clock.notify()
+	return pending
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending := f.timers[t]
+	t.done()
+	t.deadline = f.now.Add(d)
+	if d <= 0 {
+		f.fire(t)
+	} else {
+		f.timers[t] = true
+	}
+	f.notify()
+	return pending
+}
+
+// done marks a fired timer as dealt with. It must be called with the clock's mu held.
+func (t *fakeTimer) done() {
+	if t.fired {
+		t.fired = false
+		t.clock.busy--
+	}
+}
+
+// notify must be called with f.mu held.
+func (f *Fake) notify() {
+	select {
+	case f.armed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/sim"
+)
+
+var (
+	seeds            = flag.Int("seeds", 3, "Number of universes (seeds 1..N) to evaluate each strategy in")
+	gameDuration     = flag.Duration("duration", sim.DefaultScenario().GameDuration, "Maximum game time per run")
+	surveyThresholds = flag.String("survey_thresholds", "0.2", "Comma-separated survey thresholds to compare")
+	shipMixes        = flag.String("ship_mixes", ";SHIP_MINING_DRONE", "Semicolon-separated ship mixes to compare; each is a comma-separated list of ship types to buy")
+	verbose          = flag.Bool("verbose", false, "Show the activity loop's output")
+)
+
+func parseThresholds(s string) ([]float64, error) {
+	var thresholds []float64
+	for _, t := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid survey threshold %q: %w", t, err)
+		}
+		thresholds = append(thresholds, f)
+	}
+	return thresholds, nil
+}
+
+func parseShipMixes(s string) [][]api.ShipType {
+	var mixes [][]api.ShipType
+	for _, mix := range strings.Split(s, ";") {
+		var ships []api.ShipType
+		for _, ship := range strings.Split(mix, ",") {
+			if ship = strings.TrimSpace(ship); ship != "" {
+				ships = append(ships, api.ShipType(ship))
+			}
+		}
+		mixes = append(mixes, ships)
+	}
+	return mixes
+}
+
+func main() {
+	flag.Parse()
+	thresholds, err := parseThresholds(*surveyThresholds)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ships := range parseShipMixes(*shipMixes) {
+		for _, threshold := range thresholds {
+			for seed := 1; seed <= *seeds; seed++ {
+				sc := sim.DefaultScenario()
+				sc.Seed = int64(seed)
+				sc.GameDuration = *gameDuration
+				sc.Ships = ships
+				sc.Strategy.SurveyThreshold = threshold
+				if *verbose {
+					sc.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+				}
+				result, err := sim.Evaluate(context.Background(), sc)
+				if err != nil {
+					log.Fatalf("ships=%v surveyThreshold=%g seed=%d: %v", ships, threshold, seed, err)
+				}
+				fmt.Printf("ships=%v surveyThreshold=%g seed=%d: %s\n", ships, threshold, seed, result)
+			}
+		}
+	}
+}
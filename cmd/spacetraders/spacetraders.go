@@ -2,13 +2,50 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 
 	"fivebit.co.uk/spacetraders/app"
+	"fivebit.co.uk/spacetraders/logging"
+)
+
+var (
+	profile        = flag.String("profile", "", "Profile to use; defaults to $SPACETRADERS_PROFILE, or the default profile")
+	readOnly       = flag.Bool("read_only", false, "Open the state without locking it, even if another instance is running; changes to the state fail")
+	autoReregister = flag.Bool("auto_reregister", false, "After a server reset, archive the state and register the agent again without asking")
+	autoReconcile  = flag.Bool("auto_reconcile", false, "Apply corrections to saved ship and contract assignments on startup without asking")
+	exportPath     = flag.String("export", "", "Export the profile's state, cache and history to this archive file and exit")
+	importPath     = flag.String("import", "", "Import an archive written with -export into the profile and exit")
+	configPath     = flag.String("config", "", "Config file to read; defaults to config.json next to the state file")
+	record         = flag.String("record", "", "Record all API requests and responses to this cassette file")
+	replay         = flag.String("replay", "", "Serve API responses from this cassette file instead of the server")
+	replayStrict   = flag.Bool("replay_strict", false, "Fail requests which were not recorded in the -replay cassette")
+	logLevel       = flag.String("log_level", "info", "Minimum level of log messages: debug, info, warn or error")
+	logFormat      = flag.String("log_format", "text", "Log format: text or json")
+	logToFile      = flag.Bool("log_to_file", false, "Write logs to a rotating file in the XDG state directory instead of stderr")
+	metricsAddr    = flag.String("metrics_addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. localhost:9090")
 )
 
 func main() {
-	if err := app.Run(context.Background()); err != nil {
+	flag.Parse()
+	if err := app.Run(context.Background(), app.Options{
+		Profile:        *profile,
+		ReadOnly:       *readOnly,
+		AutoReconcile:  *autoReconcile,
+		AutoReregister: *autoReregister,
+		ExportPath:     *exportPath,
+		ImportPath:     *importPath,
+		ConfigPath:     *configPath,
+		RecordPath:     *record,
+		ReplayPath:     *replay,
+		ReplayStrict:   *replayStrict,
+		MetricsAddr:    *metricsAddr,
+		Logging: logging.Options{
+			Level:  *logLevel,
+			Format: *logFormat,
+			ToFile: *logToFile,
+		},
+	}); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,186 @@
+// Package config loads settings for connecting to the API, caching its data and storing the agent
+// token from a config file next to the state file, with overrides from environment variables.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+// Environment variables which override the config file.
+const (
+	EnvServerURL             = "SPACETRADERS_SERVER_URL"
+	EnvUserAgent             = "SPACETRADERS_USER_AGENT"
+	EnvProxyURL              = "SPACETRADERS_PROXY_URL"
+	EnvRequestTimeout        = "SPACETRADERS_REQUEST_TIMEOUT"
+	EnvDialTimeout           = "SPACETRADERS_DIAL_TIMEOUT"
+	EnvTLSCAFile             = "SPACETRADERS_TLS_CA_FILE"
+	EnvTLSInsecureSkipVerify = "SPACETRADERS_TLS_INSECURE_SKIP_VERIFY"
+	EnvTokenProvider         = "SPACETRADERS_TOKEN_PROVIDER"
+)
+
+type Config struct {
+	// ServerURL is the base URL of the API, including the version, e.g.
+	// https://api.spacetraders.io/v2. Empty means the default server.
+	ServerURL string `json:"serverUrl,omitempty"`
+	// UserAgent replaces the default User-Agent header.
+	UserAgent string `json:"userAgent,omitempty"`
+	// ProxyURL sends all requests through an HTTP proxy. Empty means use the proxy from the
+	// standard HTTP_PROXY/HTTPS_PROXY environment variables, if any.
+	ProxyURL string   `json:"proxyUrl,omitempty"`
+	Timeouts Timeouts `json:"timeouts,omitempty"`
+	TLS      TLS      `json:"tls,omitempty"`
+	Cache    Cache    `json:"cache,omitempty"`
+	Token    Token    `json:"token,omitempty"`
+	Backups  Backups  `json:"backups,omitempty"`
+}
+
+// Timeouts of zero mean no timeout (or the standard library default, for Dial and TLSHandshake).
+type Timeouts struct {
+	// Request limits each request as a whole, including reading the response body. Retries and rate
+	// limit waits are not included.
+	Request        Duration `json:"request,omitempty"`
+	Dial           Duration `json:"dial,omitempty"`
+	TLSHandshake   Duration `json:"tlsHandshake,omitempty"`
+	ResponseHeader Duration `json:"responseHeader,omitempty"`
+}
+
+type TLS struct {
+	// CAFile is a PEM file of certificate authorities to trust in addition to the system roots, e.g.
+	// for a recording proxy.
+	CAFile string `json:"caFile,omitempty"`
+	// InsecureSkipVerify disables certificate verification. Only use this against a local server.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// Cache holds how long cached systems and waypoints are used before being fetched again. Zero means
+// the default of a day.
+type Cache struct {
+	SystemTTL   Duration `json:"systemTtl,omitempty"`
+	WaypointTTL Duration `json:"waypointTtl,omitempty"`
+}
+
+// Backups control the rolling backups of the state file made when it is updated.
+type Backups struct {
+	// Keep is how many backups to keep. Zero means 10; negative disables backups.
+	Keep int `json:"keep,omitempty"`
+	// Interval is the minimum time between backups. Zero means 10 minutes.
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// Token providers.
+const (
+	// TokenProviderState keeps the token in the state file.
+	TokenProviderState = "state"
+	// TokenProviderEnv reads the token from an environment variable.
+	TokenProviderEnv = "env"
+	// TokenProviderFile keeps the token in a file named token in the profile directory, readable only
+	// by the user.
+	TokenProviderFile = "file"
+	// TokenProviderEncrypted keeps the token in a file named token.enc in the profile directory,
+	// encrypted with a passphrase.
+	TokenProviderEncrypted = "encrypted"
+)
+
+// Token holds where the agent token is kept.
+type Token struct {
+	// Provider is one of the TokenProvider constants. Empty means TokenProviderState.
+	Provider string `json:"provider,omitempty"`
+	// Env is the environment variable read by TokenProviderEnv. Empty means SPACETRADERS_TOKEN.
+	Env string `json:"env,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in the config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// DefaultPath returns the path of the config file, in the same directory as the state file.
+func DefaultPath() (string, error) {
+	return xdg.ConfigFile(filepath.Join("spacetraders", "config.json"))
+}
+
+// Load reads the config file at path, if it exists, and applies any environment overrides. An empty
+// path means DefaultPath.
+func Load(path string) (Config, error) {
+	if path == "" {
+		var err error
+		if path, err = DefaultPath(); err != nil {
+			return Config{}, err
+		}
+	}
+	cfg := Config{}
+	bs, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return Config{}, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(bs, &cfg); err != nil {
+			return Config{}, fmt.Errorf("reading config %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	for env, field := range map[string]*string{
+		EnvServerURL:     &cfg.ServerURL,
+		EnvUserAgent:     &cfg.UserAgent,
+		EnvProxyURL:      &cfg.ProxyURL,
+		EnvTLSCAFile:     &cfg.TLS.CAFile,
+		EnvTokenProvider: &cfg.Token.Provider,
+	} {
+		if v, ok := lookup(env); ok {
+			*field = v
+		}
+	}
+	for env, field := range map[string]*Duration{
+		EnvRequestTimeout: &cfg.Timeouts.Request,
+		EnvDialTimeout:    &cfg.Timeouts.Dial,
+	} {
+		if v, ok := lookup(env); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", env, err)
+			}
+			*field = Duration(d)
+		}
+	}
+	switch cfg.Token.Provider {
+	case "", TokenProviderState, TokenProviderEnv, TokenProviderFile, TokenProviderEncrypted:
+	default:
+		return fmt.Errorf("unknown token provider %q", cfg.Token.Provider)
+	}
+	if v, ok := lookup(EnvTLSInsecureSkipVerify); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvTLSInsecureSkipVerify, err)
+		}
+		cfg.TLS.InsecureSkipVerify = b
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package fakeserver
+
+import (
+	"net/http"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+func (s *Server) routeContracts(r *http.Request, as *agentState, parts []string) (int, any, *gameError) {
+	if len(parts) == 0 && r.Method == http.MethodGet {
+		var contracts []api.Contract
+		for _, id := range as.contractIDs {
+			contracts = append(contracts, *as.contracts[id])
+		}
+		page, err := paginate(r, contracts)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusOK, page, nil
+	}
+	if len(parts) == 0 {
+		return 0, nil, newError(http.StatusMethodNotAllowed, 405, "Method %s not allowed", r.Method)
+	}
+
+	c, ok := as.contracts[parts[0]]
+	if !ok {
+		return 0, nil, newError(http.StatusNotFound, 404, "Contract %s not found", parts[0])
+	}
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		return http.StatusOK, *c, nil
+	}
+	if len(parts) != 2 || r.Method != http.MethodPost {
+		return 0, nil, newError(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+	}
+	switch parts[1] {
+	case "accept":
+		return s.acceptContract(as, c)
+	case "deliver":
+		return s.deliverContract(r, as, c)
+	case "fulfill":
+		return s.fulfillContract(as, c)
+	}
+	return 0, nil, newError(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+}
+
+func (s *Server) acceptContract(as *agentState, c *api.Contract) (int, any, *gameError) {
+	if c.Accepted {
+		return 0, nil, newError(http.StatusBadRequest, 4501, "Contract %s has already been accepted", c.Id)
+	}
+	if c.DeadlineToAccept != nil && s.Now().After(*c.DeadlineToAccept) {
+		return 0, nil, newError(http.StatusBadRequest, 4503, "Contract %s can no longer be accepted", c.Id)
+	}
+	c.Accepted = true
+	as.agent.Credits += c.Terms.Payment.OnAccepted
+	return http.StatusOK, api.AcceptContract200ResponseData{Agent: as.agent, Contract: *c}, nil
+}
+
+func (s *Server) checkContractOpen(c *api.Contract) *gameError {
+	if !c.Accepted {
+		return newError(http.StatusBadRequest, 4505, "Contract %s has not been accepted", c.Id)
+	}
+	if c.Fulfilled {
+		return newError(http.StatusBadRequest, 4504, "Contract %s has already been fulfilled", c.Id)
+	}
+	if s.Now().After(c.Terms.Deadline) {
+		return newError(http.StatusBadRequest, 4503, "The deadline for contract %s has passed", c.Id)
+	}
+	return nil
+}
+
+func (s *Server) deliverContract(r *http.Request, as *agentState, c *api.Contract) (int, any, *gameError) {
+	req := api.DeliverContractRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	if err := s.checkContractOpen(c); err != nil {
+		return 0, nil, err
+	}
+	ss, ok := as.ships[req.ShipSymbol]
+	if !ok {
+		return 0, nil, newError(http.StatusNotFound, 404, "Ship %s not found", req.ShipSymbol)
+	}
+	s.settle(ss)
+	if ss.ship.Nav.Status != api.SHIPNAVSTATUS_DOCKED {
+		return 0, nil, newError(http.StatusBadRequest, 4244, "Ship %s must be docked to deliver goods", req.ShipSymbol)
+	}
+	var deliver *api.ContractDeliverGood
+	for i := range c.Terms.Deliver {
+		if c.Terms.Deliver[i].TradeSymbol == req.TradeSymbol {
+			deliver = &c.Terms.Deliver[i]
+		}
+	}
+	if deliver == nil {
+		return 0, nil, newError(http.StatusBadRequest, 4508, "Contract %s does not require %s", c.Id, req.TradeSymbol)
+	}
+	if deliver.DestinationSymbol != ss.ship.Nav.WaypointSymbol {
+		return 0, nil, newError(http.StatusBadRequest, 4510, "%s must be delivered to %s", req.TradeSymbol, deliver.DestinationSymbol)
+	}
+	if deliver.UnitsFulfilled+req.Units > deliver.UnitsRequired {
+		return 0, nil, newError(http.StatusBadRequest, 4509, "Contract %s requires only %d more units of %s", c.Id, deliver.UnitsRequired-deliver.UnitsFulfilled, req.TradeSymbol)
+	}
+	if err := removeCargo(&ss.ship.Cargo, req.TradeSymbol, req.Units); err != nil {
+		return 0, nil, err
+	}
+	deliver.UnitsFulfilled += req.Units
+	return http.StatusOK, api.DeliverContract200ResponseData{Contract: *c, Cargo: ss.ship.Cargo}, nil
+}
+
+func (s *Server) fulfillContract(as *agentState, c *api.Contract) (int, any, *gameError) {
+	if err := s.checkContractOpen(c); err != nil {
+		return 0, nil, err
+	}
+	for _, d := range c.Terms.Deliver {
+		if d.UnitsFulfilled < d.UnitsRequired {
+			return 0, nil, newError(http.StatusBadRequest, 4502, "Contract %s still requires %d units of %s", c.Id, d.UnitsRequired-d.UnitsFulfilled, d.TradeSymbol)
+		}
+	}
+	c.Fulfilled = true
+	as.agent.Credits += c.Terms.Payment.OnFulfilled
+	s.stats.ContractsFulfilled++
+	return http.StatusOK, api.AcceptContract200ResponseData{Agent: as.agent, Contract: *c}, nil
+}
@@ -0,0 +1,525 @@
+package fakeserver
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+func (s *Server) routeFleet(r *http.Request, as *agentState, parts []string) (int, any, *gameError) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			return s.getMyShips(r, as)
+		case http.MethodPost:
+			return s.purchaseShip(r, as)
+		}
+		return 0, nil, newError(http.StatusMethodNotAllowed, 405, "Method %s not allowed", r.Method)
+	}
+
+	ss, ok := as.ships[parts[0]]
+	if !ok {
+		return 0, nil, newError(http.StatusNotFound, 404, "Ship %s not found", parts[0])
+	}
+	s.settle(ss)
+
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		return http.StatusOK, ss.ship, nil
+	}
+	if len(parts) != 2 || r.Method != http.MethodPost {
+		return 0, nil, newError(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+	}
+	switch parts[1] {
+	case "dock":
+		return s.dock(ss)
+	case "orbit":
+		return s.orbit(ss)
+	case "navigate":
+		return s.navigate(r, ss)
+	case "refuel":
+		return s.refuel(as, ss)
+	case "extract":
+		return s.extract(r, ss)
+	case "survey":
+		return s.survey(ss)
+	case "sell":
+		return s.sell(r, as, ss)
+	case "purchase":
+		return s.purchase(r, as, ss)
+	case "jettison":
+		return s.jettison(r, ss)
+	}
+	return 0, nil, newError(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+}
+
+// settle completes the ship's journey if it has arrived.
+func (s *Server) settle(ss *shipState) {
+	nav := &ss.ship.Nav
+	if nav.Status == api.SHIPNAVSTATUS_IN_TRANSIT && !s.Now().Before(nav.Route.Arrival) {
+		nav.Status = api.SHIPNAVSTATUS_IN_ORBIT
+		nav.WaypointSymbol = nav.Route.Destination.Symbol
+		nav.SystemSymbol = nav.Route.Destination.SystemSymbol
+	}
+}
+
+func (s *Server) getMyShips(r *http.Request, as *agentState) (int, any, *gameError) {
+	var ships []api.Ship
+	for _, id := range as.shipIDs {
+		s.settle(as.ships[id])
+		ships = append(ships, as.ships[id].ship)
+	}
+	page, err := paginate(r, ships)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, page, nil
+}
+
+func (s *Server) purchaseShip(r *http.Request, as *agentState) (int, any, *gameError) {
+	req := api.PurchaseShipRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	ws, ok := s.waypoints[req.WaypointSymbol]
+	if !ok || ws.shipyard == nil {
+		return 0, nil, newError(http.StatusNotFound, 404, "Shipyard not found at %s", req.WaypointSymbol)
+	}
+	if !s.shipPresent(as, req.WaypointSymbol) {
+		return 0, nil, newError(http.StatusBadRequest, 4001, "A ship must be present at %s to purchase ships", req.WaypointSymbol)
+	}
+	var listing *api.ShipyardShip
+	for i := range ws.shipyard {
+		if *ws.shipyard[i].Type == req.ShipType {
+			listing = &ws.shipyard[i]
+		}
+	}
+	if listing == nil {
+		return 0, nil, newError(http.StatusUnprocessableEntity, 422, "Ship type %s not sold at %s", req.ShipType, req.WaypointSymbol)
+	}
+	if as.agent.Credits < listing.PurchasePrice {
+		return 0, nil, newError(http.StatusBadRequest, 4216, "Insufficient credits: %d required, %d available", listing.PurchasePrice, as.agent.Credits)
+	}
+	as.agent.Credits -= listing.PurchasePrice
+	ss := s.addShip(as, req.ShipType, req.WaypointSymbol)
+	return http.StatusCreated, api.PurchaseShip201ResponseData{
+		Agent: as.agent,
+		Ship:  ss.ship,
+		Transaction: api.ShipyardTransaction{
+			WaypointSymbol: req.WaypointSymbol,
+			ShipSymbol:     ss.ship.Symbol,
+			Price:          listing.PurchasePrice,
+			AgentSymbol:    as.agent.Symbol,
+			Timestamp:      s.Now(),
+		},
+	}, nil
+}
+
+func inTransitError(ss *shipState) *gameError {
+	return newError(http.StatusBadRequest, 4214, "Ship %s is in transit to %s until %s", ss.ship.Symbol, ss.ship.Nav.Route.Destination.Symbol, ss.ship.Nav.Route.Arrival)
+}
+
+func (s *Server) dock(ss *shipState) (int, any, *gameError) {
+	if ss.ship.Nav.Status == api.SHIPNAVSTATUS_IN_TRANSIT {
+		return 0, nil, inTransitError(ss)
+	}
+	ss.ship.Nav.Status = api.SHIPNAVSTATUS_DOCKED
+	return http.StatusOK, api.OrbitShip200ResponseData{Nav: ss.ship.Nav}, nil
+}
+
+func (s *Server) orbit(ss *shipState) (int, any, *gameError) {
+	if ss.ship.Nav.Status == api.SHIPNAVSTATUS_IN_TRANSIT {
+		return 0, nil, inTransitError(ss)
+	}
+	ss.ship.Nav.Status = api.SHIPNAVSTATUS_IN_ORBIT
+	return http.StatusOK, api.OrbitShip200ResponseData{Nav: ss.ship.Nav}, nil
+}
+
+func routeWaypoint(wp api.Waypoint) api.ShipNavRouteWaypoint {
+	return api.ShipNavRouteWaypoint{
+		Symbol:       wp.Symbol,
+		Type:         wp.Type,
+		SystemSymbol: wp.SystemSymbol,
+		X:            wp.X,
+		Y:            wp.Y,
+	}
+}
+
+// FuelRequired and TravelTime use the same formulae as the real server for CRUISE flight mode.
+func FuelRequired(from, to api.Waypoint) int32 {
+	distance := distance(from, to)
+	if distance == 0 {
+		return 0
+	}
+	return int32(math.Max(1, math.Round(distance)))
+}
+
+func TravelTime(from, to api.Waypoint, speed int32) time.Duration {
+	seconds := math.Round(15 + math.Max(1, distance(from, to))*25/float64(speed))
+	return time.Duration(seconds) * time.Second
+}
+
+func distance(from, to api.Waypoint) float64 {
+	return math.Hypot(float64(to.X-from.X), float64(to.Y-from.Y))
+}
+
+func (s *Server) navigate(r *http.Request, ss *shipState) (int, any, *gameError) {
+	req := api.NavigateShipRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	ship := &ss.ship
+	if ship.Nav.Status == api.SHIPNAVSTATUS_IN_TRANSIT {
+		return 0, nil, newError(http.StatusBadRequest, 4200, "Ship %s is currently in transit", ship.Symbol)
+	}
+	if ship.Nav.Status != api.SHIPNAVSTATUS_IN_ORBIT {
+		return 0, nil, newError(http.StatusBadRequest, 4236, "Ship %s must be in orbit to navigate", ship.Symbol)
+	}
+	if req.WaypointSymbol == ship.Nav.WaypointSymbol {
+		return 0, nil, newError(http.StatusBadRequest, 4204, "Ship %s is already at %s", ship.Symbol, req.WaypointSymbol)
+	}
+	dest, ok := s.waypoints[req.WaypointSymbol]
+	if !ok {
+		return 0, nil, newError(http.StatusBadRequest, 4201, "Waypoint %s does not exist", req.WaypointSymbol)
+	}
+	if dest.waypoint.SystemSymbol != ship.Nav.SystemSymbol {
+		return 0, nil, newError(http.StatusBadRequest, 4202, "Waypoint %s is outside the current system", req.WaypointSymbol)
+	}
+	origin := s.waypoints[ship.Nav.WaypointSymbol].waypoint
+	fuel := FuelRequired(origin, dest.waypoint)
+	if fuel > ship.Fuel.Current && ship.Fuel.Capacity > 0 {
+		return 0, nil, newError(http.StatusBadRequest, 4203, "Navigation requires %d fuel; ship has %d", fuel, ship.Fuel.Current)
+	}
+	now := s.Now()
+	if ship.Fuel.Capacity > 0 {
+		ship.Fuel.Current -= fuel
+		ship.Fuel.Consumed = &api.ShipFuelConsumed{Amount: fuel, Timestamp: now}
+	}
+	ship.Nav.Status = api.SHIPNAVSTATUS_IN_TRANSIT
+	ship.Nav.Route = api.ShipNavRoute{
+		Departure:     routeWaypoint(origin),
+		Destination:   routeWaypoint(dest.waypoint),
+		DepartureTime: now,
+		Arrival:       now.Add(s.realDuration(TravelTime(origin, dest.waypoint, ship.Engine.Speed))),
+	}
+	return http.StatusOK, api.NavigateShip200ResponseData{Fuel: ship.Fuel, Nav: ship.Nav}, nil
+}
+
+func (s *Server) refuel(as *agentState, ss *shipState) (int, any, *gameError) {
+	ship := &ss.ship
+	if ship.Nav.Status != api.SHIPNAVSTATUS_DOCKED {
+		return 0, nil, newError(http.StatusBadRequest, 4225, "Ship %s must be docked to refuel", ship.Symbol)
+	}
+	price, ok := s.marketPrice(s.waypoints[ship.Nav.WaypointSymbol].market, "FUEL")
+	if !ok {
+		return 0, nil, newError(http.StatusBadRequest, 4226, "Fuel is not sold at %s", ship.Nav.WaypointSymbol)
+	}
+	units := ship.Fuel.Capacity - ship.Fuel.Current
+	cost := units * price
+	if cost > as.agent.Credits {
+		return 0, nil, newError(http.StatusBadRequest, 4600, "Refuelling costs %d credits; %d available", cost, as.agent.Credits)
+	}
+	as.agent.Credits -= cost
+	ship.Fuel.Current = ship.Fuel.Capacity
+	return http.StatusOK, api.RefuelShip200ResponseData{
+		Agent:       as.agent,
+		Fuel:        ship.Fuel,
+		Transaction: s.transaction(ship, "FUEL", "PURCHASE", units, price),
+	}, nil
+}
+
+func (s *Server) transaction(ship *api.Ship, tradeSymbol, txType string, units, price int32) api.MarketTransaction {
+	return api.MarketTransaction{
+		WaypointSymbol: ship.Nav.WaypointSymbol,
+		ShipSymbol:     ship.Symbol,
+		TradeSymbol:    tradeSymbol,
+		Type:           txType,
+		Units:          units,
+		PricePerUnit:   price,
+		TotalPrice:     units * price,
+		Timestamp:      s.Now(),
+	}
+}
+
+func (s *Server) cooldown(ss *shipState, total time.Duration) api.Cooldown {
+	now := s.Now()
+	remaining := ss.cooldown.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return api.Cooldown{
+		ShipSymbol:       ss.ship.Symbol,
+		TotalSeconds:     int32(total.Seconds()),
+		RemainingSeconds: int32(math.Ceil(remaining.Seconds())),
+		Expiration:       ptr(ss.cooldown),
+	}
+}
+
+func (s *Server) checkCooldown(ss *shipState, total time.Duration) *gameError {
+	if !s.Now().Before(ss.cooldown) {
+		return nil
+	}
+	err := newError(http.StatusConflict, 4000, "Ship %s is on cooldown", ss.ship.Symbol)
+	err.data = map[string]any{"cooldown": s.cooldown(ss, total)}
+	return err
+}
+
+func hasMount(ship api.Ship, prefix string) bool {
+	return mountStrength(ship, prefix) > 0
+}
+
+// mountStrength returns the total strength of the ship's mounts with the given prefix.
+func mountStrength(ship api.Ship, prefix string) int32 {
+	var strength int32
+	for _, m := range ship.Mounts {
+		if !strings.HasPrefix(m.Symbol, prefix) {
+			continue
+		}
+		if m.Strength == nil {
+			strength++
+		} else {
+			strength += *m.Strength
+		}
+	}
+	return strength
+}
+
+// pickDeposit chooses a deposit at random, in proportion to the deposits' weights.
+func (s *Server) pickDeposit(deposits []Deposit) string {
+	total := 0
+	for _, d := range deposits {
+		total += d.Weight
+	}
+	n := s.rand.Intn(total)
+	for _, d := range deposits {
+		if n -= d.Weight; n < 0 {
+			return d.Symbol
+		}
+	}
+	return deposits[len(deposits)-1].Symbol
+}
+
+func (s *Server) extract(r *http.Request, ss *shipState) (int, any, *gameError) {
+	req := api.ExtractResourcesRequest{}
+	if err := decodeOptionalBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	ship := &ss.ship
+	if ship.Nav.Status != api.SHIPNAVSTATUS_IN_ORBIT {
+		return 0, nil, newError(http.StatusBadRequest, 4236, "Ship %s must be in orbit to extract", ship.Symbol)
+	}
+	if !hasMount(*ship, "MOUNT_MINING_LASER") {
+		return 0, nil, newError(http.StatusBadRequest, 4227, "Ship %s has no mining laser", ship.Symbol)
+	}
+	ws := s.waypoints[ship.Nav.WaypointSymbol]
+	if len(ws.deposits) == 0 {
+		return 0, nil, newError(http.StatusBadRequest, 4205, "Cannot extract resources at %s", ship.Nav.WaypointSymbol)
+	}
+	total := s.realDuration(extractCooldown)
+	if err := s.checkCooldown(ss, total); err != nil {
+		return 0, nil, err
+	}
+	if ship.Cargo.Units >= ship.Cargo.Capacity {
+		return 0, nil, newError(http.StatusBadRequest, 4228, "Ship %s cargo hold is full", ship.Symbol)
+	}
+
+	deposits := ws.deposits
+	if req.Survey != nil {
+		survey, ok := s.surveys[req.Survey.Signature]
+		if !ok || survey.survey.Symbol != ship.Nav.WaypointSymbol {
+			return 0, nil, newError(http.StatusBadRequest, 4220, "Survey %s is not valid at %s", req.Survey.Signature, ship.Nav.WaypointSymbol)
+		}
+		if !s.Now().Before(survey.survey.Expiration) {
+			return 0, nil, newError(http.StatusBadRequest, 4221, "Survey %s has expired", req.Survey.Signature)
+		}
+		if survey.remaining <= 0 {
+			return 0, nil, newError(http.StatusBadRequest, 4224, "Survey %s has been exhausted", req.Survey.Signature)
+		}
+		survey.remaining--
+		deposits = nil
+		for _, d := range survey.survey.Deposits {
+			deposits = append(deposits, Deposit{Symbol: d.Symbol, Weight: 1})
+		}
+		s.stats.SurveyedExtractions++
+	}
+
+	symbol := s.pickDeposit(deposits)
+	// Yields scale with laser strength; a Mining Laser I (strength 10) yields 3-10 units.
+	strength := mountStrength(*ship, "MOUNT_MINING_LASER")
+	units := int32(3 + s.rand.Intn(8))
+	units = (units*strength + 9) / 10
+	if space := ship.Cargo.Capacity - ship.Cargo.Units; units > space {
+		units = space
+	}
+	addCargo(&ship.Cargo, symbol, units)
+	s.stats.Extractions++
+	s.stats.UnitsExtracted += units
+
+	ss.cooldown = s.Now().Add(total)
+	return http.StatusCreated, api.ExtractResources201ResponseData{
+		Cooldown: s.cooldown(ss, total),
+		Extraction: api.Extraction{
+			ShipSymbol: ship.Symbol,
+			Yield:      api.ExtractionYield{Symbol: symbol, Units: units},
+		},
+		Cargo: ship.Cargo,
+	}, nil
+}
+
+func addCargo(cargo *api.ShipCargo, symbol string, units int32) {
+	cargo.Units += units
+	for i := range cargo.Inventory {
+		if cargo.Inventory[i].Symbol == symbol {
+			cargo.Inventory[i].Units += units
+			return
+		}
+	}
+	cargo.Inventory = append(cargo.Inventory, api.ShipCargoItem{
+		Symbol:      symbol,
+		Name:        symbol,
+		Description: symbol,
+		Units:       units,
+	})
+}
+
+func removeCargo(cargo *api.ShipCargo, symbol string, units int32) *gameError {
+	for i := range cargo.Inventory {
+		if cargo.Inventory[i].Symbol != symbol {
+			continue
+		}
+		if cargo.Inventory[i].Units < units {
+			return newError(http.StatusBadRequest, 4219, "Cargo has %d units of %s; %d requested", cargo.Inventory[i].Units, symbol, units)
+		}
+		cargo.Units -= units
+		cargo.Inventory[i].Units -= units
+		if cargo.Inventory[i].Units == 0 {
+			cargo.Inventory = append(cargo.Inventory[:i], cargo.Inventory[i+1:]...)
+		}
+		return nil
+	}
+	return newError(http.StatusBadRequest, 4218, "Cargo does not contain %s", symbol)
+}
+
+func (s *Server) survey(ss *shipState) (int, any, *gameError) {
+	ship := &ss.ship
+	if ship.Nav.Status != api.SHIPNAVSTATUS_IN_ORBIT {
+		return 0, nil, newError(http.StatusBadRequest, 4223, "Ship %s must be in orbit to survey", ship.Symbol)
+	}
+	if !hasMount(*ship, "MOUNT_SURVEYOR") {
+		return 0, nil, newError(http.StatusBadRequest, 4240, "Ship %s has no surveyor", ship.Symbol)
+	}
+	ws := s.waypoints[ship.Nav.WaypointSymbol]
+	if len(ws.deposits) == 0 {
+		return 0, nil, newError(http.StatusBadRequest, 4222, "Cannot survey %s", ship.Nav.WaypointSymbol)
+	}
+	total := s.realDuration(surveyCooldown)
+	if err := s.checkCooldown(ss, total); err != nil {
+		return 0, nil, err
+	}
+
+	now := s.Now()
+	var surveys []api.Survey
+	for i := 0; i < 2; i++ {
+		survey := api.Survey{
+			Signature:  fmt.Sprintf("%s-%06X", ship.Nav.WaypointSymbol, s.rand.Intn(1<<24)),
+			Symbol:     ship.Nav.WaypointSymbol,
+			Expiration: now.Add(s.realDuration(surveyLifetime)),
+			Size:       "SMALL",
+		}
+		for j := 0; j < 5+s.rand.Intn(3); j++ {
+			survey.Deposits = append(survey.Deposits, api.SurveyDeposit{Symbol: s.pickDeposit(ws.deposits)})
+		}
+		s.surveys[survey.Signature] = &surveyState{survey: survey, remaining: surveyExtractions}
+		surveys = append(surveys, survey)
+	}
+	s.stats.Surveys++
+
+	ss.cooldown = now.Add(total)
+	return http.StatusCreated, api.CreateSurvey201ResponseData{
+		Cooldown: s.cooldown(ss, total),
+		Surveys:  surveys,
+	}, nil
+}
+
+func (s *Server) sell(r *http.Request, as *agentState, ss *shipState) (int, any, *gameError) {
+	req := api.SellCargoRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	ship := &ss.ship
+	if ship.Nav.Status != api.SHIPNAVSTATUS_DOCKED {
+		return 0, nil, newError(http.StatusBadRequest, 4244, "Ship %s must be docked to sell cargo", ship.Symbol)
+	}
+	ws := s.waypoints[ship.Nav.WaypointSymbol]
+	if ws.market == nil {
+		return 0, nil, newError(http.StatusNotFound, 4603, "No market at %s", ship.Nav.WaypointSymbol)
+	}
+	if _, ok := s.marketPrice(ws.market, req.Symbol); !ok {
+		return 0, nil, newError(http.StatusBadRequest, 4602, "Market at %s does not buy %s", ship.Nav.WaypointSymbol, req.Symbol)
+	}
+	if err := removeCargo(&ship.Cargo, req.Symbol, req.Units); err != nil {
+		return 0, nil, err
+	}
+	price := s.marketSell(ws.market, req.Symbol, req.Units)
+	as.agent.Credits += price * req.Units
+	s.stats.Sales += price * req.Units
+	return http.StatusCreated, api.SellCargo201ResponseData{
+		Agent:       as.agent,
+		Cargo:       ship.Cargo,
+		Transaction: s.transaction(ship, req.Symbol, "SELL", req.Units, price),
+	}, nil
+}
+
+func (s *Server) purchase(r *http.Request, as *agentState, ss *shipState) (int, any, *gameError) {
+	req := api.PurchaseCargoRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	ship := &ss.ship
+	if ship.Nav.Status != api.SHIPNAVSTATUS_DOCKED {
+		return 0, nil, newError(http.StatusBadRequest, 4244, "Ship %s must be docked to purchase cargo", ship.Symbol)
+	}
+	ws := s.waypoints[ship.Nav.WaypointSymbol]
+	if ws.market == nil {
+		return 0, nil, newError(http.StatusNotFound, 4603, "No market at %s", ship.Nav.WaypointSymbol)
+	}
+	price, ok := s.marketPurchasePrice(ws.market, req.Symbol)
+	if !ok {
+		return 0, nil, newError(http.StatusBadRequest, 4601, "Market at %s does not sell %s", ship.Nav.WaypointSymbol, req.Symbol)
+	}
+	if limit := ws.market.goods[req.Symbol].tradeVolume(); req.Units > limit {
+		return 0, nil, newError(http.StatusBadRequest, 4604, "Market at %s trades at most %d units of %s at once", ship.Nav.WaypointSymbol, limit, req.Symbol)
+	}
+	if space := ship.Cargo.Capacity - ship.Cargo.Units; req.Units > space {
+		return 0, nil, newError(http.StatusBadRequest, 4217, "Ship %s has space for %d units; %d requested", ship.Symbol, space, req.Units)
+	}
+	if cost := price * req.Units; cost > as.agent.Credits {
+		return 0, nil, newError(http.StatusBadRequest, 4600, "Purchase costs %d credits; %d available", cost, as.agent.Credits)
+	}
+	price = s.marketBuy(ws.market, req.Symbol, req.Units)
+	as.agent.Credits -= price * req.Units
+	s.stats.Purchases += price * req.Units
+	addCargo(&ship.Cargo, req.Symbol, req.Units)
+	return http.StatusCreated, api.SellCargo201ResponseData{
+		Agent:       as.agent,
+		Cargo:       ship.Cargo,
+		Transaction: s.transaction(ship, req.Symbol, "PURCHASE", req.Units, price),
+	}, nil
+}
+
+func (s *Server) jettison(r *http.Request, ss *shipState) (int, any, *gameError) {
+	req := api.JettisonRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	if ss.ship.Nav.Status == api.SHIPNAVSTATUS_IN_TRANSIT {
+		return 0, nil, inTransitError(ss)
+	}
+	if err := removeCargo(&ss.ship.Cargo, req.Symbol, req.Units); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, api.Jettison200ResponseData{Cargo: ss.ship.Cargo}, nil
+}
@@ -0,0 +1,149 @@
+package fakeserver
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+// MarketGood describes a good traded at a market.
+type MarketGood struct {
+	// BasePrice is the price paid per unit when the market is not saturated.
+	BasePrice int32
+	// Volume is the number of units which halves the price when sold at once. Zero means prices
+	// never move.
+	Volume int32
+}
+
+// How long (in game time) it takes a saturated market to recover half the difference between its
+// current and base prices.
+const marketRecoveryHalfLife = 30 * time.Minute
+
+// Markets charge this much more than they pay, so goods can't be bought and sold back for a profit.
+const purchaseMarkup = 1.2
+
+// defaultTradeVolume is the most units of a good which can be traded at once when its Volume is zero.
+const defaultTradeVolume = 100
+
+type marketGood struct {
+	MarketGood
+	price   float64
+	updated time.Time
+}
+
+type market struct {
+	goods map[string]*marketGood
+}
+
+func newMarket(goods map[string]MarketGood, now time.Time) *market {
+	if goods == nil {
+		return nil
+	}
+	m := &market{goods: map[string]*marketGood{}}
+	for symbol, good := range goods {
+		m.goods[symbol] = &marketGood{
+			MarketGood: good,
+			price:      float64(good.BasePrice),
+			updated:    now,
+		}
+	}
+	return m
+}
+
+// recover moves the price back towards its base price, given how much game time has passed.
+func (g *marketGood) recover(elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	remaining := math.Pow(0.5, float64(elapsed)/float64(marketRecoveryHalfLife))
+	g.price = float64(g.BasePrice) + (g.price-float64(g.BasePrice))*remaining
+}
+
+// marketPrice returns the current price of a good, and whether the market trades it at all.
+func (s *Server) marketPrice(m *market, symbol string) (int32, bool) {
+	if m == nil {
+		return 0, false
+	}
+	g, ok := m.goods[symbol]
+	if !ok {
+		return 0, false
+	}
+	now := s.Now()
+	g.recover(s.gameDuration(now.Sub(g.updated)))
+	g.updated = now
+	return int32(math.Max(1, math.Round(g.price))), true
+}
+
+// marketSell records units being sold to the market, returning the price per unit. Selling lowers
+// the price for subsequent sales.
+func (s *Server) marketSell(m *market, symbol string, units int32) int32 {
+	price, _ := s.marketPrice(m, symbol)
+	g := m.goods[symbol]
+	if g.Volume > 0 {
+		g.price *= math.Pow(0.5, float64(units)/float64(g.Volume))
+	}
+	return price
+}
+
+// marketPurchasePrice returns the current price the market charges for a good, and whether the
+// market trades it at all.
+func (s *Server) marketPurchasePrice(m *market, symbol string) (int32, bool) {
+	price, ok := s.marketPrice(m, symbol)
+	if !ok {
+		return 0, false
+	}
+	return int32(math.Ceil(float64(price) * purchaseMarkup)), true
+}
+
+// marketBuy records units being bought from the market, returning the price per unit. Buying raises
+// the price for subsequent purchases and sales.
+func (s *Server) marketBuy(m *market, symbol string, units int32) int32 {
+	price, _ := s.marketPurchasePrice(m, symbol)
+	g := m.goods[symbol]
+	if g.Volume > 0 {
+		g.price /= math.Pow(0.5, float64(units)/float64(g.Volume))
+	}
+	return price
+}
+
+// tradeVolume returns the most units of a good which can be traded in one transaction.
+func (g *marketGood) tradeVolume() int32 {
+	if g.Volume > 0 {
+		return g.Volume
+	}
+	return defaultTradeVolume
+}
+
+func (s *Server) getMarket(as *agentState, system, waypoint string) (int, any, *gameError) {
+	ws, ok := s.waypoints[waypoint]
+	if !ok || ws.waypoint.SystemSymbol != system || ws.market == nil {
+		return 0, nil, newError(http.StatusNotFound, 4603, "Market not found at %s", waypoint)
+	}
+	var symbols []string
+	for symbol := range ws.market.goods {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	// Every good is both bought and sold.
+	market := api.Market{Symbol: waypoint, Exports: []api.TradeGood{}, Imports: []api.TradeGood{}}
+	present := s.shipPresent(as, waypoint)
+	for _, symbol := range symbols {
+		market.Exchange = append(market.Exchange, api.TradeGood{Symbol: symbol, Name: symbol, Description: symbol})
+		// Prices are only available with a ship present
+		if present {
+			sellPrice, _ := s.marketPrice(ws.market, symbol)
+			purchasePrice, _ := s.marketPurchasePrice(ws.market, symbol)
+			market.TradeGoods = append(market.TradeGoods, api.MarketTradeGood{
+				Symbol:        symbol,
+				TradeVolume:   ws.market.goods[symbol].tradeVolume(),
+				Supply:        "MODERATE",
+				PurchasePrice: purchasePrice,
+				SellPrice:     sellPrice,
+			})
+		}
+	}
+	return http.StatusOK, market, nil
+}
@@ -0,0 +1,379 @@
+// Package fakeserver is an in-process stand-in for the SpaceTraders API, for driving the
+// application without a network connection. It implements the endpoints used by this project,
+// keeping consistent state and applying the game rules the application depends on: cooldowns,
+// cargo capacity, fuel use, travel time, credits and contract terms.
+package fakeserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+type Server struct {
+	// Now returns the current game time. Replace it to control cooldowns, travel and expiry.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	universe  Universe
+	stats     Stats
+	httpSrv   *httptest.Server
+	rand      *rand.Rand
+	nextID    int
+	agents    map[string]*agentState
+	tokens    map[string]*agentState
+	systems   map[string][]string
+	waypoints map[string]*waypointState
+	surveys   map[string]*surveyState
+	// resetDate is the date of the most recent reset; staleTokens holds tokens issued before it.
+	resetDate   time.Time
+	staleTokens map[string]bool
+}
+
+type agentState struct {
+	agent       api.Agent
+	token       string
+	ships       map[string]*shipState
+	shipIDs     []string
+	contracts   map[string]*api.Contract
+	contractIDs []string
+}
+
+type shipState struct {
+	ship     api.Ship
+	cooldown time.Time
+}
+
+type waypointState struct {
+	waypoint api.Waypoint
+	market   *market
+	shipyard []api.ShipyardShip
+	deposits []Deposit
+}
+
+type surveyState struct {
+	survey    api.Survey
+	remaining int
+}
+
+// New creates a fake server with the default universe, without starting an HTTP server.
+func New() *Server {
+	return NewWithUniverse(DefaultUniverse(), time.Now)
+}
+
+// NewWithUniverse creates a fake server serving the given universe, without starting an HTTP
+// server.
+func NewWithUniverse(u Universe, now func() time.Time) *Server {
+	s := &Server{
+		Now:       now,
+		rand:      rand.New(rand.NewSource(1)),
+		agents:    map[string]*agentState{},
+		tokens:    map[string]*agentState{},
+		systems:   map[string][]string{},
+		waypoints: map[string]*waypointState{},
+		surveys:   map[string]*surveyState{},
+	}
+	s.resetDate = startOfDay(now())
+	s.staleTokens = map[string]bool{}
+	s.createUniverse(u)
+	return s
+}
+
+// Reset simulates a server reset: all agents are removed, their tokens stop working and the
+// universe returns to its initial state.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token := range s.tokens {
+		s.staleTokens[token] = true
+	}
+	s.agents = map[string]*agentState{}
+	s.tokens = map[string]*agentState{}
+	s.systems = map[string][]string{}
+	s.waypoints = map[string]*waypointState{}
+	s.surveys = map[string]*surveyState{}
+	s.resetDate = startOfDay(s.Now())
+	s.createUniverse(s.universe)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Start creates a fake server with the default universe, listening on a local port.
+func Start() *Server {
+	return New().Start()
+}
+
+// Start starts serving on a local port.
+func (s *Server) Start() *Server {
+	s.httpSrv = httptest.NewServer(s)
+	return s
+}
+
+func (s *Server) URL() string {
+	return s.httpSrv.URL
+}
+
+func (s *Server) Close() {
+	s.httpSrv.Close()
+}
+
+// Client returns an API client which talks to this server.
+func (s *Server) Client() *api.APIClient {
+	cfg := api.NewConfiguration()
+	cfg.Servers = api.ServerConfigurations{{URL: s.httpSrv.URL}}
+	cfg.HTTPClient = s.httpSrv.Client()
+	return api.NewAPIClient(cfg)
+}
+
+// Agent returns the current state of an agent.
+func (s *Server) Agent(symbol string) (api.Agent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	as, ok := s.agents[symbol]
+	if !ok {
+		return api.Agent{}, false
+	}
+	return as.agent, true
+}
+
+// Ship returns the current state of a ship, belonging to any agent.
+func (s *Server) Ship(symbol string) (api.Ship, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, as := range s.agents {
+		if ss, ok := as.ships[symbol]; ok {
+			s.settle(ss)
+			return ss.ship, true
+		}
+	}
+	return api.Ship{}, false
+}
+
+// Contract returns the current state of a contract, belonging to any agent.
+func (s *Server) Contract(id string) (api.Contract, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, as := range s.agents {
+		if c, ok := as.contracts[id]; ok {
+			return *c, true
+		}
+	}
+	return api.Contract{}, false
+}
+
+// Stats counts what has happened on the server, across all agents.
+type Stats struct {
+	Extractions         int
+	SurveyedExtractions int
+	UnitsExtracted      int32
+	Surveys             int
+	// Sales is the total number of credits paid for goods sold to markets.
+	Sales int32
+	// Purchases is the total number of credits paid for goods bought from markets.
+	Purchases          int32
+	ContractsFulfilled int
+}
+
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+type gameError struct {
+	status  int
+	code    int
+	message string
+	data    map[string]any
+}
+
+func (e *gameError) Error() string {
+	return fmt.Sprintf("%d: %s", e.code, e.message)
+}
+
+func newError(status, code int, format string, args ...any) *gameError {
+	return &gameError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+type paged struct {
+	data any
+	meta api.Meta
+}
+
+// unwrapped is a response which is not wrapped in a data object.
+type unwrapped struct {
+	data any
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, data, err := s.route(r)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(err.status)
+		errBody := map[string]any{
+			"code":    err.code,
+			"message": err.message,
+		}
+		if err.data != nil {
+			errBody["data"] = err.data
+		}
+		json.NewEncoder(w).Encode(map[string]any{"error": errBody})
+		return
+	}
+	w.WriteHeader(status)
+	if u, ok := data.(unwrapped); ok {
+		json.NewEncoder(w).Encode(u.data)
+		return
+	}
+	if p, ok := data.(paged); ok {
+		json.NewEncoder(w).Encode(map[string]any{"data": p.data, "meta": p.meta})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func (s *Server) route(r *http.Request) (int, any, *gameError) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) > 0 && parts[0] == "v2" {
+		parts = parts[1:]
+	}
+	if r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "register" {
+		return s.register(r)
+	}
+	if r.Method == http.MethodGet && (len(parts) == 0 || len(parts) == 1 && parts[0] == "") {
+		return s.status()
+	}
+
+	if len(parts) >= 3 && parts[0] == "systems" && parts[2] == "waypoints" && r.Method == http.MethodGet {
+		as, err := s.authenticate(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		switch {
+		case len(parts) == 3:
+			return s.getSystemWaypoints(r, parts[1])
+		case len(parts) == 4:
+			return s.getWaypoint(parts[1], parts[3])
+		case len(parts) == 5 && parts[4] == "shipyard":
+			return s.getShipyard(as, parts[1], parts[3])
+		case len(parts) == 5 && parts[4] == "market":
+			return s.getMarket(as, parts[1], parts[3])
+		}
+	}
+
+	if len(parts) >= 2 && parts[0] == "my" {
+		as, err := s.authenticate(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		switch {
+		case r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "agent":
+			return http.StatusOK, as.agent, nil
+		case parts[1] == "ships":
+			return s.routeFleet(r, as, parts[2:])
+		case parts[1] == "contracts":
+			return s.routeContracts(r, as, parts[2:])
+		}
+	}
+
+	return 0, nil, newError(http.StatusNotFound, 404, "Route %s %s not found", r.Method, r.URL.Path)
+}
+
+func (s *Server) authenticate(r *http.Request) (*agentState, *gameError) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, newError(http.StatusUnauthorized, 4103, "Missing bearer token")
+	}
+	as, ok := s.tokens[token]
+	if !ok && s.staleTokens[token] {
+		return nil, newError(http.StatusUnauthorized, 4113, "Failed to parse token. Token reset_date does not match the server. Server resets happen on a weekly to bi-weekly frequency during alpha. After a reset, you should re-register your agent.")
+	}
+	if !ok {
+		return nil, newError(http.StatusUnauthorized, 4104, "Invalid bearer token")
+	}
+	return as, nil
+}
+
+func (s *Server) status() (int, any, *gameError) {
+	return http.StatusOK, unwrapped{api.GetStatus200Response{
+		Status:    "SpaceTraders is currently online and available to play",
+		Version:   "fake",
+		ResetDate: s.resetDate.Format("2006-01-02"),
+		ServerResets: api.GetStatus200ResponseServerResets{
+			Next:      s.resetDate.AddDate(0, 0, 7).Format(time.RFC3339),
+			Frequency: "weekly",
+		},
+	}}, nil
+}
+
+func decodeBody(r *http.Request, into any) *gameError {
+	if err := json.NewDecoder(r.Body).Decode(into); err != nil {
+		return newError(http.StatusUnprocessableEntity, 422, "Invalid request body: %v", err)
+	}
+	return nil
+}
+
+func decodeOptionalBody(r *http.Request, into any) *gameError {
+	bs, err := io.ReadAll(r.Body)
+	if err != nil {
+		return newError(http.StatusBadRequest, 400, "Reading request body: %v", err)
+	}
+	if len(bytes.TrimSpace(bs)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(bs, into); err != nil {
+		return newError(http.StatusUnprocessableEntity, 422, "Invalid request body: %v", err)
+	}
+	return nil
+}
+
+// paginate returns the page of items requested by the page and limit query parameters.
+func paginate[T any](r *http.Request, items []T) (paged, *gameError) {
+	page, limit := int32(1), int32(10)
+	if p := r.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			return paged{}, newError(http.StatusUnprocessableEntity, 422, "Invalid page %q", p)
+		}
+		page = int32(n)
+	}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 20 {
+			return paged{}, newError(http.StatusUnprocessableEntity, 422, "Invalid limit %q", l)
+		}
+		limit = int32(n)
+	}
+	start := int((page - 1) * limit)
+	end := start + int(limit)
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+	return paged{
+		data: items[start:end],
+		meta: api.Meta{Total: int32(len(items)), Page: page, Limit: limit},
+	}, nil
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s%06d", prefix, s.nextID)
+}
@@ -0,0 +1,365 @@
+package fakeserver
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+const (
+	System          = "X1-TS01"
+	Headquarters    = "X1-TS01-10001A"
+	AsteroidField   = "X1-TS01-20002B"
+	TradingPost     = "X1-TS01-30003C"
+	StartingCredits = 150000
+
+	// Durations are in game time; see Universe.TimeScale.
+	extractCooldown   = 70 * time.Second
+	surveyCooldown    = 70 * time.Second
+	surveyLifetime    = 15 * time.Minute
+	contractDeadline  = 7 * 24 * time.Hour
+	acceptDeadline    = 24 * time.Hour
+	surveyExtractions = 10
+)
+
+var agentSymbolRegexp = regexp.MustCompile(`^[A-Z0-9]{3,14}$`)
+
+// Universe describes the game world served by a Server.
+type Universe struct {
+	Waypoints []WaypointSpec
+	// Headquarters is where new agents' ships start.
+	Headquarters    string
+	StartingCredits int32
+	// The good and number of units required by each new agent's first contract.
+	ContractGood  string
+	ContractUnits int32
+	// TimeScale is the number of seconds of game time which pass for each real second. Cooldowns,
+	// travel times, expiry and market recovery are all shortened accordingly. Zero means 1.
+	TimeScale float64
+}
+
+type WaypointSpec struct {
+	Waypoint api.Waypoint
+	// Goods bought and sold by the market at this waypoint, if any.
+	Market map[string]MarketGood
+	// Ships for sale at this waypoint, if it has a shipyard.
+	Shipyard []api.ShipyardShip
+	// Goods which can be extracted at this waypoint, with their relative abundance.
+	Deposits []Deposit
+}
+
+type Deposit struct {
+	Symbol string
+	Weight int
+}
+
+func trait(symbol, name string) api.WaypointTrait {
+	return api.WaypointTrait{Symbol: symbol, Name: name, Description: name}
+}
+
+// DefaultUniverse is a single small system with a headquarters (with a market and shipyard), an
+// asteroid field and a trading post.
+func DefaultUniverse() Universe {
+	return Universe{
+		Headquarters:    Headquarters,
+		StartingCredits: StartingCredits,
+		ContractGood:    "IRON_ORE",
+		ContractUnits:   30,
+		Waypoints: []WaypointSpec{
+			{
+				Waypoint: api.Waypoint{
+					Symbol:       Headquarters,
+					Type:         api.WAYPOINTTYPE_PLANET,
+					SystemSymbol: System,
+					X:            0,
+					Y:            0,
+					Faction:      &api.WaypointFaction{Symbol: "COSMIC"},
+					Traits:       []api.WaypointTrait{trait("MARKETPLACE", "Marketplace"), trait("SHIPYARD", "Shipyard")},
+				},
+				Market: map[string]MarketGood{
+					"FUEL":             {BasePrice: 2},
+					"IRON_ORE":         {BasePrice: 40},
+					"COPPER_ORE":       {BasePrice: 45},
+					"ALUMINUM_ORE":     {BasePrice: 50},
+					"QUARTZ_SAND":      {BasePrice: 20},
+					"SILICON_CRYSTALS": {BasePrice: 35},
+					"ICE_WATER":        {BasePrice: 10},
+				},
+				Shipyard: []api.ShipyardShip{
+					ShipyardShip(api.SHIPTYPE_MINING_DRONE, 80000),
+					ShipyardShip(api.SHIPTYPE_PROBE, 20000),
+				},
+			},
+			{
+				Waypoint: api.Waypoint{
+					Symbol:       AsteroidField,
+					Type:         api.WAYPOINTTYPE_ASTEROID_FIELD,
+					SystemSymbol: System,
+					X:            30,
+					Y:            40,
+					Traits:       []api.WaypointTrait{trait("MINERAL_DEPOSITS", "Mineral Deposits")},
+				},
+				Deposits: []Deposit{
+					{"IRON_ORE", 1},
+					{"COPPER_ORE", 1},
+					{"ALUMINUM_ORE", 1},
+					{"QUARTZ_SAND", 1},
+					{"SILICON_CRYSTALS", 1},
+					{"ICE_WATER", 1},
+					{"PRECIOUS_STONES", 1},
+				},
+			},
+			{
+				Waypoint: api.Waypoint{
+					Symbol:       TradingPost,
+					Type:         api.WAYPOINTTYPE_ORBITAL_STATION,
+					SystemSymbol: System,
+					X:            -60,
+					Y:            80,
+					Traits:       []api.WaypointTrait{trait("MARKETPLACE", "Marketplace")},
+				},
+				Market: map[string]MarketGood{
+					"FUEL":            {BasePrice: 2},
+					"PRECIOUS_STONES": {BasePrice: 120},
+					"IRON_ORE":        {BasePrice: 55},
+				},
+			},
+		},
+	}
+}
+
+func (s *Server) createUniverse(u Universe) {
+	s.universe = u
+	if s.universe.TimeScale == 0 {
+		s.universe.TimeScale = 1
+	}
+	for _, spec := range u.Waypoints {
+		s.waypoints[spec.Waypoint.Symbol] = &waypointState{
+			waypoint: spec.Waypoint,
+			market:   newMarket(spec.Market, s.Now()),
+			shipyard: spec.Shipyard,
+			deposits: spec.Deposits,
+		}
+		s.systems[spec.Waypoint.SystemSymbol] = append(s.systems[spec.Waypoint.SystemSymbol], spec.Waypoint.Symbol)
+	}
+}
+
+// realDuration converts a duration in game time to real time.
+func (s *Server) realDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / s.universe.TimeScale)
+}
+
+// gameDuration converts a duration in real time to game time.
+func (s *Server) gameDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * s.universe.TimeScale)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// ShipyardShip returns a shipyard listing for a ship type.
+func ShipyardShip(shipType api.ShipType, price int32) api.ShipyardShip {
+	ship := NewShip("", shipType)
+	name := strings.Title(strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(string(shipType), "SHIP_"), "_", " ")))
+	return api.ShipyardShip{
+		Type:          ptr(shipType),
+		Name:          name,
+		Description:   name,
+		PurchasePrice: price,
+		Frame:         ship.Frame,
+		Reactor:       ship.Reactor,
+		Engine:        ship.Engine,
+		Modules:       ship.Modules,
+		Mounts:        ship.Mounts,
+	}
+}
+
+func mount(symbol, name string, strength int32) api.ShipMount {
+	return api.ShipMount{Symbol: symbol, Name: name, Strength: ptr(strength)}
+}
+
+// NewShip returns a ship of the given type, docked at the default headquarters.
+func NewShip(symbol string, shipType api.ShipType) api.Ship {
+	ship := api.Ship{
+		Symbol: symbol,
+		Nav: api.ShipNav{
+			SystemSymbol:   System,
+			WaypointSymbol: Headquarters,
+			Status:         api.SHIPNAVSTATUS_DOCKED,
+			FlightMode:     api.SHIPNAVFLIGHTMODE_CRUISE,
+		},
+		Registration: api.ShipRegistration{Name: symbol, FactionSymbol: "COSMIC"},
+		Frame:        api.ShipFrame{Symbol: "FRAME_DRONE", Name: "Drone"},
+		Reactor:      api.ShipReactor{Symbol: "REACTOR_CHEMICAL_I", Name: "Chemical Reactor", PowerOutput: 15},
+	}
+	switch shipType {
+	case api.SHIPTYPE_COMMAND_FRIGATE:
+		ship.Registration.Role = api.SHIPROLE_COMMAND
+		ship.Frame = api.ShipFrame{Symbol: "FRAME_FRIGATE", Name: "Frigate", FuelCapacity: 1200}
+		ship.Engine = api.ShipEngine{Symbol: "ENGINE_ION_DRIVE_I", Name: "Ion Drive", Speed: 20}
+		ship.Mounts = []api.ShipMount{
+			mount("MOUNT_SURVEYOR_I", "Surveyor I", 1),
+			mount("MOUNT_MINING_LASER_I", "Mining Laser I", 10),
+		}
+		ship.Cargo.Capacity = 60
+	case api.SHIPTYPE_MINING_DRONE:
+		ship.Registration.Role = api.SHIPROLE_EXCAVATOR
+		ship.Frame.FuelCapacity = 100
+		ship.Engine = api.ShipEngine{Symbol: "ENGINE_IMPULSE_DRIVE_I", Name: "Impulse Drive", Speed: 10}
+		ship.Mounts = []api.ShipMount{mount("MOUNT_MINING_LASER_I", "Mining Laser I", 10)}
+		ship.Cargo.Capacity = 30
+	default:
+		ship.Registration.Role = api.SHIPROLE_SATELLITE
+		ship.Engine = api.ShipEngine{Symbol: "ENGINE_IMPULSE_DRIVE_I", Name: "Impulse Drive", Speed: 10}
+	}
+	ship.Fuel = api.ShipFuel{Current: ship.Frame.FuelCapacity, Capacity: ship.Frame.FuelCapacity}
+	return ship
+}
+
+func (s *Server) register(r *http.Request) (int, any, *gameError) {
+	req := api.RegisterRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	if !agentSymbolRegexp.MatchString(req.Symbol) {
+		return 0, nil, newError(http.StatusUnprocessableEntity, 422, "Invalid agent symbol %q", req.Symbol)
+	}
+	if _, ok := s.agents[req.Symbol]; ok {
+		return 0, nil, newError(http.StatusConflict, 4109, "Agent symbol %s has already been claimed", req.Symbol)
+	}
+
+	as := &agentState{
+		agent: api.Agent{
+			AccountId:       s.newID("account"),
+			Symbol:          req.Symbol,
+			Headquarters:    s.universe.Headquarters,
+			Credits:         s.universe.StartingCredits,
+			StartingFaction: req.Faction,
+		},
+		token:     s.newID("token-" + req.Symbol + "-"),
+		ships:     map[string]*shipState{},
+		contracts: map[string]*api.Contract{},
+	}
+	s.agents[req.Symbol] = as
+	s.tokens[as.token] = as
+
+	ship := s.addShip(as, api.SHIPTYPE_COMMAND_FRIGATE, s.universe.Headquarters)
+	contract := s.addContract(as, "PROCUREMENT", s.universe.ContractGood, s.universe.Headquarters, s.universe.ContractUnits)
+
+	return http.StatusCreated, api.Register201ResponseData{
+		Agent:    as.agent,
+		Contract: *contract,
+		Faction:  api.Faction{Symbol: req.Faction, Name: req.Faction, Headquarters: s.universe.Headquarters},
+		Ship:     ship.ship,
+		Token:    as.token,
+	}, nil
+}
+
+func (s *Server) addShip(as *agentState, shipType api.ShipType, waypoint string) *shipState {
+	symbol := as.agent.Symbol + "-" + itoa(len(as.shipIDs)+1)
+	ship := NewShip(symbol, shipType)
+	ship.Nav.WaypointSymbol = waypoint
+	ship.Nav.SystemSymbol = s.waypoints[waypoint].waypoint.SystemSymbol
+	ss := &shipState{ship: ship}
+	as.ships[symbol] = ss
+	as.shipIDs = append(as.shipIDs, symbol)
+	return ss
+}
+
+// AddContract offers a new procurement contract to an agent, returning its ID.
+func (s *Server) AddContract(agentSymbol, tradeSymbol, destination string, units int32) string {
+	return s.AddContractOfType(agentSymbol, "PROCUREMENT", tradeSymbol, destination, units)
+}
+
+// AddContractOfType offers a new contract of the given type (e.g. "TRANSPORT") to an agent,
+// returning its ID. All types are delivered and fulfilled in the same way.
+func (s *Server) AddContractOfType(agentSymbol, contractType, tradeSymbol, destination string, units int32) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addContract(s.agents[agentSymbol], contractType, tradeSymbol, destination, units).Id
+}
+
+func (s *Server) addContract(as *agentState, contractType, tradeSymbol, destination string, units int32) *api.Contract {
+	now := s.Now()
+	c := &api.Contract{
+		Id:            s.newID("contract"),
+		FactionSymbol: "COSMIC",
+		Type:          contractType,
+		Terms: api.ContractTerms{
+			Deadline: now.Add(s.realDuration(contractDeadline)),
+			Payment:  api.ContractPayment{OnAccepted: 5000, OnFulfilled: 20000},
+			Deliver: []api.ContractDeliverGood{{
+				TradeSymbol:       tradeSymbol,
+				DestinationSymbol: destination,
+				UnitsRequired:     units,
+			}},
+		},
+		Expiration:       now.Add(s.realDuration(acceptDeadline)),
+		DeadlineToAccept: ptr(now.Add(s.realDuration(acceptDeadline))),
+	}
+	as.contracts[c.Id] = c
+	as.contractIDs = append(as.contractIDs, c.Id)
+	return c
+}
+
+func (s *Server) getSystemWaypoints(r *http.Request, system string) (int, any, *gameError) {
+	symbols, ok := s.systems[system]
+	if !ok {
+		return 0, nil, newError(http.StatusNotFound, 404, "System %s not found", system)
+	}
+	var waypoints []api.Waypoint
+	for _, symbol := range symbols {
+		waypoints = append(waypoints, s.waypoints[symbol].waypoint)
+	}
+	page, err := paginate(r, waypoints)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, page, nil
+}
+
+func (s *Server) getWaypoint(system, waypoint string) (int, any, *gameError) {
+	ws, ok := s.waypoints[waypoint]
+	if !ok || ws.waypoint.SystemSymbol != system {
+		return 0, nil, newError(http.StatusNotFound, 404, "Waypoint %s not found in system %s", waypoint, system)
+	}
+	return http.StatusOK, ws.waypoint, nil
+}
+
+func (s *Server) getShipyard(as *agentState, system, waypoint string) (int, any, *gameError) {
+	ws, ok := s.waypoints[waypoint]
+	if !ok || ws.waypoint.SystemSymbol != system || ws.shipyard == nil {
+		return 0, nil, newError(http.StatusNotFound, 404, "Shipyard not found at %s", waypoint)
+	}
+	shipyard := api.Shipyard{Symbol: waypoint}
+	for _, ship := range ws.shipyard {
+		shipyard.ShipTypes = append(shipyard.ShipTypes, api.ShipyardShipTypesInner{Type: ship.Type})
+	}
+	// Details and prices are only available with a ship present
+	if s.shipPresent(as, waypoint) {
+		shipyard.Ships = ws.shipyard
+	}
+	return http.StatusOK, shipyard, nil
+}
+
+func (s *Server) shipPresent(as *agentState, waypoint string) bool {
+	for _, ss := range as.ships {
+		s.settle(ss)
+		if ss.ship.Nav.WaypointSymbol == waypoint && ss.ship.Nav.Status != api.SHIPNAVSTATUS_IN_TRANSIT {
+			return true
+		}
+	}
+	return false
+}
+
+func itoa(n int) string {
+	const digits = "0123456789ABCDEF"
+	if n < 16 {
+		return string(digits[n])
+	}
+	return itoa(n/16) + string(digits[n%16])
+}
@@ -1,11 +1,14 @@
 module fivebit.co.uk/spacetraders
 
-go 1.20
+go 1.21
 
 require (
 	bitbucket.org/creachadair/stringset v0.0.11
-	github.com/adrg/xdg v0.4.0 // indirect
+	github.com/adrg/xdg v0.4.0
+	github.com/manifoldco/promptui v0.9.0
+)
+
+require (
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
-	github.com/manifoldco/promptui v0.9.0 // indirect
 	golang.org/x/sys v0.0.0-20211025201205-69cdffdb9359 // indirect
 )
@@ -0,0 +1,127 @@
+// Package journal keeps an append-only record of the actions taken in the game, one JSON object per
+// line, for auditing what the automation did and building reports.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry records one action.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Ship     string    `json:"ship,omitempty"`
+	Contract string    `json:"contract,omitempty"`
+	// Request summarises what was asked for, e.g. the trade symbol and units to sell.
+	Request map[string]any `json:"request,omitempty"`
+	// Response holds the key fields of the result, e.g. the price paid or the arrival time.
+	Response map[string]any `json:"response,omitempty"`
+	// Error is set if the action failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Journal appends entries to a file. A nil *Journal discards entries.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// Open opens the journal at path for appending, creating it if necessary. A read-only journal can
+// be queried but discards new entries.
+func Open(path string, readOnly bool) (*Journal, error) {
+	j := &Journal{path: path}
+	if readOnly {
+		return j, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.f = f
+	return j, nil
+}
+
+// Record appends an entry to the journal.
+func (j *Journal) Record(e Entry) error {
+	if j == nil || j.f == nil {
+		return nil
+	}
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.f.Write(append(bs, '\n'))
+	return err
+}
+
+func (j *Journal) Close() error {
+	if j == nil || j.f == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// Filter selects journal entries. Empty fields match everything.
+type Filter struct {
+	Ship   string
+	Action string
+	// Since and Until bound the entries' times; Since is inclusive and Until exclusive.
+	Since time.Time
+	Until time.Time
+}
+
+func (f Filter) Match(e Entry) bool {
+	return (f.Ship == "" || e.Ship == f.Ship) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Query returns the entries matching a filter, oldest first.
+func (j *Journal) Query(f Filter) ([]Entry, error) {
+	if j == nil {
+		return nil, nil
+	}
+	return Read(j.path, f)
+}
+
+// Read returns the entries in the journal at path matching a filter, oldest first. Lines which
+// cannot be decoded, such as one cut short by a crash, are skipped.
+func Read(path string, f Filter) ([]Entry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if f.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return entries, nil
+}
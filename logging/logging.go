@@ -0,0 +1,69 @@
+// Package logging configures the structured logger used by the automation.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrg/xdg"
+)
+
+type Options struct {
+	// Level is one of debug, info, warn or error. Empty means info.
+	Level string
+	// Format is text or json. Empty means text.
+	Format string
+	// ToFile writes logs to a rotating file in the XDG state directory instead of stderr.
+	ToFile bool
+}
+
+// New builds a logger from the options. The returned closer must be closed on exit.
+func New(opts Options) (*slog.Logger, io.Closer, error) {
+	level := slog.LevelInfo
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %q: %w", opts.Level, err)
+		}
+	}
+
+	var w io.WriteCloser = nopCloser{os.Stderr}
+	if opts.ToFile {
+		path, err := xdg.StateFile(filepath.Join("spacetraders", "spacetraders.log"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if w, err = newRotatingFile(path, defaultMaxSize, defaultBackups); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		w.Close()
+		return nil, nil, fmt.Errorf("invalid log format %q; must be text or json", opts.Format)
+	}
+	return slog.New(handler), w, nil
+}
+
+// Discard returns a logger which drops everything, e.g. for simulations.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
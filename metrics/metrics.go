@@ -0,0 +1,306 @@
+// Package metrics is a minimal implementation of Prometheus counters, gauges and histograms, with
+// an HTTP handler serving them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram bucket upper bounds suitable for request latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Registry holds metrics to be exposed together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// Default is the registry used by the New* functions and Handler.
+var Default = &Registry{}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic(fmt.Sprintf("metric %s registered twice", m.name()))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics in the Prometheus text format, sorted by name.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return Default
+}
+
+// Serve listens on addr and serves the default registry at /metrics in the background. Errors
+// listening are returned; errors serving afterwards are not.
+func Serve(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening for metrics on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go http.Serve(l, mux)
+	return nil
+}
+
+// vec holds the series of one metric, keyed by their label values.
+type vec[T any] struct {
+	mu         sync.Mutex
+	metricName string
+	help       string
+	labels     []string
+	series     map[string]*T
+	values     map[string][]string
+	newSeries  func() *T
+}
+
+func newVec[T any](name, help string, labels []string, newSeries func() *T) *vec[T] {
+	v := &vec[T]{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		series:     map[string]*T{},
+		values:     map[string][]string{},
+		newSeries:  newSeries,
+	}
+	if len(labels) == 0 {
+		// Metrics without labels have exactly one series, which is exposed from the start.
+		v.get(nil)
+	}
+	return v
+}
+
+func (v *vec[T]) name() string {
+	return v.metricName
+}
+
+// get returns the series for the given label values, creating it if necessary. v.mu must be held.
+func (v *vec[T]) get(labelValues []string) *T {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels; got %d values", v.metricName, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newSeries()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), labelValues...)
+	}
+	return s
+}
+
+// each calls fn for every series in a stable order. v.mu must be held.
+func (v *vec[T]) each(fn func(labels string, s *T)) {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fn(formatLabels(v.labels, v.values[k]), v.series[k])
+	}
+}
+
+func (v *vec[T]) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, escapeHelp(v.help), v.metricName, typ)
+}
+
+type CounterVec struct {
+	*vec[float64]
+}
+
+// NewCounter registers a counter in the default registry. Counters only go up.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels, func() *float64 { return new(float64) })}
+	Default.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(n float64, labelValues ...string) {
+	if n < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.metricName))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labelValues) += n
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, "counter")
+	c.each(func(labels string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labels, formatFloat(*v))
+	})
+}
+
+type GaugeVec struct {
+	*vec[float64]
+}
+
+// NewGauge registers a gauge in the default registry.
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, labels, func() *float64 { return new(float64) })}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(n float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues) = n
+}
+
+func (g *GaugeVec) Add(n float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues) += n
+}
+
+// Batch returns an empty, unregistered gauge with the same name and labels, to collect a complete
+// set of values which then replace the gauge's with Replace.
+func (g *GaugeVec) Batch() *GaugeVec {
+	return &GaugeVec{newVec(g.metricName, g.help, g.labels, g.newSeries)}
+}
+
+// Replace swaps in all the series of a batch at once, removing any the batch doesn't have, so that
+// scrapes never see a partly updated gauge.
+func (g *GaugeVec) Replace(batch *GaugeVec) {
+	batch.mu.Lock()
+	series, values := batch.series, batch.values
+	batch.mu.Unlock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.series, g.values = series, values
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w, "gauge")
+	g.each(func(labels string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, labels, formatFloat(*v))
+	})
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type HistogramVec struct {
+	*vec[histogram]
+	buckets []float64
+}
+
+// NewHistogram registers a histogram in the default registry. Buckets are upper bounds in
+// increasing order; a +Inf bucket is added automatically.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		vec:     newVec(name, help, labels, func() *histogram { return &histogram{counts: make([]uint64, len(buckets))} }),
+		buckets: buckets,
+	}
+	Default.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues)
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, "histogram")
+	h.each(func(labels string, s *histogram) {
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLabel(labels, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLabel(labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, s.count)
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to an already-formatted label set.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + labelEscaper.Replace(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -21,6 +21,26 @@ func Prompt(label string, validate func(input string) error) (string, error) {
 	}).Run()
 }
 
+// Password asks for a secret without echoing it.
+func Password(label string) (string, error) {
+	return (&promptui.Prompt{
+		Label: label,
+		Mask:  '*',
+	}).Run()
+}
+
+// Confirm asks a yes/no question, returning false if the user declines.
+func Confirm(label string) (bool, error) {
+	_, err := (&promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+	}).Run()
+	if errors.Is(err, promptui.ErrAbort) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func NoValidate(_ string) error {
 	return nil
 }
@@ -0,0 +1,138 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// pbkdf2Iterations is the work factor for deriving keys from passphrases.
+const pbkdf2Iterations = 600000
+
+// Encrypted keeps the token in a file encrypted with AES-256-GCM, using a key derived from a
+// passphrase with PBKDF2-HMAC-SHA256.
+type Encrypted struct {
+	Path string
+	// Passphrase is called to get the passphrase, e.g. by reading an environment variable or asking
+	// the user.
+	Passphrase func() (string, error)
+}
+
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// ErrWrongPassphrase is returned when the token file cannot be decrypted with the passphrase given.
+var ErrWrongPassphrase = errors.New("wrong passphrase, or the token file is corrupt")
+
+func (e Encrypted) Token() (string, error) {
+	bs, err := os.ReadFile(e.Path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s does not exist", ErrNoToken, e.Path)
+	} else if err != nil {
+		return "", err
+	}
+	ef := encryptedFile{}
+	if err := json.Unmarshal(bs, &ef); err != nil {
+		return "", fmt.Errorf("reading %s: %w", e.Path, err)
+	}
+	if ef.Version != 1 {
+		return "", fmt.Errorf("reading %s: unsupported version %d", e.Path, ef.Version)
+	}
+	passphrase, err := e.Passphrase()
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(passphrase, ef.Salt, ef.Iterations)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aead.Open(nil, ef.Nonce, ef.Ciphertext, nil)
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+	return string(plaintext), nil
+}
+
+func (e Encrypted) SetToken(token string) error {
+	passphrase, err := e.Passphrase()
+	if err != nil {
+		return err
+	}
+	ef := encryptedFile{
+		Version:    1,
+		Iterations: pbkdf2Iterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(ef.Salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(passphrase, ef.Salt, ef.Iterations)
+	if err != nil {
+		return err
+	}
+	ef.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(ef.Nonce); err != nil {
+		return err
+	}
+	ef.Ciphertext = aead.Seal(nil, ef.Nonce, []byte(token), nil)
+	bs, err := json.Marshal(ef)
+	if err != nil {
+		return err
+	}
+	return writeFile(e.Path, bs)
+}
+
+func (e Encrypted) Writable() bool {
+	return true
+}
+
+func (e Encrypted) String() string {
+	return e.Path + " (encrypted)"
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key of keyLen bytes from a password as described in RFC 8018.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	u := make([]byte, 0, sha256.Size)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,113 @@
+// Package secret stores the agent token outside the state file: in an environment variable, a
+// file readable only by the user, or a file encrypted with a passphrase.
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Provider holds an agent token.
+type Provider interface {
+	// Token returns the saved token.
+	Token() (string, error)
+	// SetToken saves a new token.
+	SetToken(token string) error
+	// Writable reports whether SetToken can work, so that callers can check before registering an
+	// agent whose token would otherwise be lost.
+	Writable() bool
+	// String describes where the token is kept, for messages.
+	String() string
+}
+
+// ErrNoToken is returned when no token has been saved.
+var ErrNoToken = errors.New("no token saved")
+
+// Mask returns a form of token which is safe to print, showing only its last few characters.
+func Mask(token string) string {
+	if len(token) <= 12 {
+		return strings.Repeat("*", len(token))
+	}
+	return "..." + token[len(token)-6:]
+}
+
+// Env reads the token from an environment variable. It cannot save new tokens.
+type Env struct {
+	Var string
+}
+
+func (e Env) Token() (string, error) {
+	token := os.Getenv(e.Var)
+	if token == "" {
+		return "", fmt.Errorf("%w: $%s is not set", ErrNoToken, e.Var)
+	}
+	return token, nil
+}
+
+func (e Env) SetToken(string) error {
+	return fmt.Errorf("cannot save a token to environment variable $%s", e.Var)
+}
+
+func (e Env) Writable() bool {
+	return false
+}
+
+func (e Env) String() string {
+	return "environment variable $" + e.Var
+}
+
+// File keeps the token in a file which only the user can read.
+type File struct {
+	Path string
+}
+
+func (f File) Token() (string, error) {
+	bs, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s does not exist", ErrNoToken, f.Path)
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+func (f File) SetToken(token string) error {
+	return writeFile(f.Path, []byte(token+"\n"))
+}
+
+func (f File) Writable() bool {
+	return true
+}
+
+func (f File) String() string {
+	return f.Path
+}
+
+// writeFile replaces a file with data readable only by the user, via a temporary file so that the
+// old contents survive a failed write.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,176 @@
+// Package sim evaluates automation strategies against a simulated universe served by the fake
+// server. The server and the activity loop share a fake clock which jumps straight to the next
+// time the loop is waiting for, so hours of game time pass in seconds.
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/app"
+	"fivebit.co.uk/spacetraders/clock"
+	"fivebit.co.uk/spacetraders/fakeserver"
+	"fivebit.co.uk/spacetraders/logging"
+	"fivebit.co.uk/spacetraders/state"
+)
+
+const agentSymbol = "SIMULATOR"
+
+// Scenario describes one simulation run.
+type Scenario struct {
+	// Seed and Universe control the generated universe; runs with the same seed use the same
+	// universe, so strategies can be compared fairly.
+	Seed     int64
+	Universe GenerateOptions
+	// GameDuration is the maximum amount of game time to simulate.
+	GameDuration time.Duration
+	// Ships are bought at the headquarters before starting, and assigned to the starting contract
+	// along with the command ship. Only mining ships are supported by the activity loop.
+	Ships    []api.ShipType
+	Strategy app.Strategy
+	// Logger receives the activity loop's logs. Nil discards them.
+	Logger *slog.Logger
+}
+
+func DefaultScenario() Scenario {
+	return Scenario{
+		Seed:         1,
+		Universe:     DefaultGenerateOptions(),
+		GameDuration: 12 * time.Hour,
+		Strategy:     app.DefaultStrategy(),
+	}
+}
+
+type Result struct {
+	// Fulfilled is whether the starting contract was fulfilled within the game duration.
+	Fulfilled bool
+	// Stalled is whether the run ended early because no ship had anything left to do, e.g. because
+	// their holds were full of goods they couldn't get rid of. Such a run says nothing about the
+	// strategy.
+	Stalled bool
+	// GameTime is how much game time passed before the contract was fulfilled or the run ended.
+	GameTime time.Duration
+	// Credits is the agent's credits at the end of the run, after buying ships.
+	Credits int32
+	Stats   fakeserver.Stats
+}
+
+func (r Result) String() string {
+	s := fmt.Sprintf("fulfilled=%t gameTime=%s credits=%d extractions=%d (%d surveyed) units=%d surveys=%d sales=%d",
+		r.Fulfilled, r.GameTime.Round(time.Second), r.Credits, r.Stats.Extractions, r.Stats.SurveyedExtractions,
+		r.Stats.UnitsExtracted, r.Stats.Surveys, r.Stats.Sales)
+	if r.Stalled {
+		s = "STALLED (no ship had anything left to do) " + s
+	}
+	return s
+}
+
+// Evaluate runs a scenario: it registers an agent in a fresh universe, buys the scenario's ships,
+// accepts the starting contract and runs the activity loop until the contract is fulfilled, the
+// game duration has passed or no ship has anything left to do, in which case the result is marked
+// as stalled.
+func Evaluate(ctx context.Context, sc Scenario) (Result, error) {
+	u := Generate(sc.Seed, sc.Universe)
+	start := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	srv := fakeserver.NewWithUniverse(u, clk.Now).Start()
+	defer srv.Close()
+	client := srv.Client()
+
+	reg, _, err := client.DefaultApi.Register(ctx).RegisterRequest(api.RegisterRequest{Symbol: agentSymbol, Faction: "COSMIC"}).Execute()
+	if err != nil {
+		return Result{}, fmt.Errorf("registering: %w", err)
+	}
+	ctx = context.WithValue(ctx, api.ContextAccessToken, reg.Data.Token)
+	s := state.NewInMemory(agentSymbol, "COSMIC", reg.Data.Token)
+
+	ships := []string{reg.Data.Ship.Symbol}
+	for _, shipType := range sc.Ships {
+		resp, _, err := client.FleetApi.PurchaseShip(ctx).PurchaseShipRequest(api.PurchaseShipRequest{
+			ShipType:       shipType,
+			WaypointSymbol: u.Headquarters,
+		}).Execute()
+		if err != nil {
+			return Result{}, fmt.Errorf("buying %s: %w", shipType, err)
+		}
+		ships = append(ships, resp.Data.Ship.Symbol)
+	}
+	contractID := reg.Data.Contract.Id
+	if _, _, err := client.ContractsApi.AcceptContract(ctx, contractID).Execute(); err != nil {
+		return Result{}, fmt.Errorf("accepting contract: %w", err)
+	}
+	if err := s.Update(func(ms state.MutableState) error {
+		for _, ship := range ships {
+			ms.AssignShip(contractID, ship)
+		}
+		return nil
+	}); err != nil {
+		return Result{}, err
+	}
+
+	a := app.New(client, s)
+	a.SetStrategy(sc.Strategy)
+	a.SetClock(clk)
+	if sc.Logger != nil {
+		a.SetLogger(sc.Logger)
+	} else {
+		a.SetLogger(logging.Discard())
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- a.RunActivity(runCtx)
+	}()
+
+	// Whenever every ship is waiting, move the clock to when the next one will be ready. Ships only
+	// wait between actions, so the clock never moves while a ship is making requests.
+	end := start.Add(sc.GameDuration)
+	var runErr error
+	stalled := false
+loop:
+	for {
+		if srv.Stats().ContractsFulfilled > 0 || !clk.Now().Before(end) {
+			cancel()
+			runErr = <-done
+			break
+		}
+		if a.Idle() && clk.Idle() {
+			next, ok := clk.NextDeadline()
+			if !ok {
+				// No ship has anything left to do.
+				stalled = true
+				cancel()
+				runErr = <-done
+				break
+			}
+			if next.After(end) {
+				next = end
+			}
+			clk.Set(next)
+			continue
+		}
+		select {
+		case runErr = <-done:
+			break loop
+		case <-clk.Armed():
+		}
+	}
+	if runErr != nil && !errors.Is(runErr, context.Canceled) {
+		return Result{}, runErr
+	}
+
+	stats := srv.Stats()
+	agent, _ := srv.Agent(agentSymbol)
+	return Result{
+		Fulfilled: stats.ContractsFulfilled > 0,
+		Stalled:   stalled,
+		GameTime:  clk.Now().Sub(start),
+		Credits:   agent.Credits,
+		Stats:     stats,
+	}, nil
+}
@@ -0,0 +1,146 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/fakeserver"
+)
+
+// GenerateOptions controls the shape of a generated universe.
+type GenerateOptions struct {
+	Systems            int
+	WaypointsPerSystem int
+	// Radius is the maximum distance of a waypoint from the centre of its system.
+	Radius int32
+}
+
+func DefaultGenerateOptions() GenerateOptions {
+	return GenerateOptions{
+		Systems:            3,
+		WaypointsPerSystem: 8,
+		Radius:             100,
+	}
+}
+
+type good struct {
+	symbol    string
+	basePrice int32
+	// Relative abundance in asteroid fields.
+	abundance int
+}
+
+var minerals = []good{
+	{"ICE_WATER", 10, 8},
+	{"QUARTZ_SAND", 20, 6},
+	{"SILICON_CRYSTALS", 35, 5},
+	{"IRON_ORE", 40, 5},
+	{"COPPER_ORE", 45, 4},
+	{"ALUMINUM_ORE", 50, 4},
+	{"AMMONIA_ICE", 30, 3},
+	{"SILVER_ORE", 90, 2},
+	{"GOLD_ORE", 110, 1},
+	{"PLATINUM_ORE", 140, 1},
+	{"PRECIOUS_STONES", 120, 1},
+	{"URANITE_ORE", 160, 1},
+}
+
+var waypointTypes = []api.WaypointType{
+	api.WAYPOINTTYPE_PLANET,
+	api.WAYPOINTTYPE_MOON,
+	api.WAYPOINTTYPE_ORBITAL_STATION,
+	api.WAYPOINTTYPE_GAS_GIANT,
+	api.WAYPOINTTYPE_ASTEROID_FIELD,
+}
+
+func trait(symbol, name string) api.WaypointTrait {
+	return api.WaypointTrait{Symbol: symbol, Name: name, Description: name}
+}
+
+// Generate creates a random universe from a seed. The same seed and options always produce the same
+// universe. The first system's first waypoint is the headquarters, which has a marketplace and a
+// shipyard, and the first system always has at least one asteroid field. The starting contract is
+// for a mineral found in that asteroid field, delivered to the headquarters.
+func Generate(seed int64, opts GenerateOptions) fakeserver.Universe {
+	r := rand.New(rand.NewSource(seed))
+	u := fakeserver.Universe{StartingCredits: fakeserver.StartingCredits}
+	for i := 0; i < opts.Systems; i++ {
+		system := fmt.Sprintf("X1-S%02d", i+1)
+		for j := 0; j < opts.WaypointsPerSystem; j++ {
+			wpType := waypointTypes[r.Intn(len(waypointTypes))]
+			switch {
+			case j == 0:
+				wpType = api.WAYPOINTTYPE_PLANET
+			case j == 1:
+				wpType = api.WAYPOINTTYPE_ASTEROID_FIELD
+			}
+			spec := fakeserver.WaypointSpec{
+				Waypoint: api.Waypoint{
+					Symbol:       fmt.Sprintf("%s-%d%04d%c", system, j+1, r.Intn(10000), 'A'+rune(j%26)),
+					Type:         wpType,
+					SystemSymbol: system,
+					X:            r.Int31n(2*opts.Radius+1) - opts.Radius,
+					Y:            r.Int31n(2*opts.Radius+1) - opts.Radius,
+				},
+			}
+			if i == 0 && j == 1 {
+				// Keep the first asteroid field within reach of ships starting at the headquarters.
+				hq := u.Waypoints[0].Waypoint
+				spec.Waypoint.X = hq.X + r.Int31n(opts.Radius/2+1) - opts.Radius/4
+				spec.Waypoint.Y = hq.Y + r.Int31n(opts.Radius/2+1) - opts.Radius/4
+			}
+			if wpType == api.WAYPOINTTYPE_ASTEROID_FIELD {
+				generateDeposits(r, &spec)
+			} else if j == 0 || r.Float64() < 0.5 {
+				generateMarket(r, &spec)
+			}
+			if j == 0 {
+				spec.Waypoint.Faction = &api.WaypointFaction{Symbol: "COSMIC"}
+				spec.Waypoint.Traits = append(spec.Waypoint.Traits, trait("SHIPYARD", "Shipyard"))
+				spec.Shipyard = []api.ShipyardShip{
+					fakeserver.ShipyardShip(api.SHIPTYPE_MINING_DRONE, 70000+r.Int31n(20000)),
+					fakeserver.ShipyardShip(api.SHIPTYPE_PROBE, 15000+r.Int31n(10000)),
+				}
+			}
+			u.Waypoints = append(u.Waypoints, spec)
+		}
+	}
+
+	hq, field := &u.Waypoints[0], u.Waypoints[1]
+	u.Headquarters = hq.Waypoint.Symbol
+	contractGood := field.Deposits[r.Intn(len(field.Deposits))].Symbol
+	for _, g := range minerals {
+		if g.symbol == contractGood {
+			// The headquarters market must buy the contract good, so that surplus can be sold.
+			hq.Market[g.symbol] = fakeserver.MarketGood{BasePrice: g.basePrice, Volume: 50 + r.Int31n(100)}
+		}
+	}
+	u.ContractGood = contractGood
+	u.ContractUnits = 20 + r.Int31n(40)
+	return u
+}
+
+func generateDeposits(r *rand.Rand, spec *fakeserver.WaypointSpec) {
+	spec.Waypoint.Traits = append(spec.Waypoint.Traits, trait("MINERAL_DEPOSITS", "Mineral Deposits"))
+	n := 3 + r.Intn(4)
+	for _, i := range r.Perm(len(minerals))[:n] {
+		spec.Deposits = append(spec.Deposits, fakeserver.Deposit{
+			Symbol: minerals[i].symbol,
+			Weight: minerals[i].abundance * (1 + r.Intn(3)),
+		})
+	}
+}
+
+func generateMarket(r *rand.Rand, spec *fakeserver.WaypointSpec) {
+	spec.Waypoint.Traits = append(spec.Waypoint.Traits, trait("MARKETPLACE", "Marketplace"))
+	spec.Market = map[string]fakeserver.MarketGood{
+		"FUEL": {BasePrice: 1 + r.Int31n(3)},
+	}
+	for _, i := range r.Perm(len(minerals))[:2+r.Intn(4)] {
+		g := minerals[i]
+		// Prices vary by up to 25% either way between markets.
+		price := g.basePrice * (75 + r.Int31n(51)) / 100
+		spec.Market[g.symbol] = fakeserver.MarketGood{BasePrice: price, Volume: 50 + r.Int31n(100)}
+	}
+}
@@ -0,0 +1,205 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Defaults for BackupOptions.
+const (
+	DefaultBackupKeep     = 10
+	DefaultBackupInterval = 10 * time.Minute
+)
+
+// BackupOptions control the rolling backups made when the state is updated.
+type BackupOptions struct {
+	// Keep is how many backups to keep. Zero means DefaultBackupKeep; negative disables backups.
+	Keep int
+	// Interval is the minimum time between backups, so that frequent updates don't push out older
+	// backups too quickly. Zero means DefaultBackupInterval.
+	Interval time.Duration
+}
+
+// Backup is a saved copy of the state file.
+type Backup struct {
+	Name string
+	Time time.Time
+}
+
+const backupTimeFormat = "20060102T150405Z"
+
+func backupDir(stateFilePath string) string {
+	return filepath.Join(filepath.Dir(stateFilePath), "backups")
+}
+
+// backup copies the current state file into the backups directory before it is replaced, unless
+// the latest backup is more recent than the interval (or force is set), and removes the oldest
+// backups beyond the number to keep.
+func (s *state) backup(force bool) error {
+	opts := s.backups
+	if opts.Keep < 0 {
+		return nil
+	}
+	if opts.Keep == 0 {
+		opts.Keep = DefaultBackupKeep
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultBackupInterval
+	}
+	bs, err := os.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	backups, err := listBackups(s.filePath)
+	if err != nil {
+		return err
+	}
+	now := s.now().UTC()
+	if !force && len(backups) > 0 && now.Sub(backups[0].Time) < opts.Interval {
+		return nil
+	}
+	dir := backupDir(s.filePath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := "state-" + now.Format(backupTimeFormat) + ".json"
+	if len(backups) > 0 && backups[0].Name == name {
+		return nil
+	}
+	if err := writeFileAtomic(filepath.Join(dir, name), bs, 0600); err != nil {
+		return err
+	}
+	backups = append([]Backup{{Name: name, Time: now}}, backups...)
+	for _, b := range backups[min(len(backups), opts.Keep):] {
+		if err := os.Remove(filepath.Join(dir, b.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listBackups returns the backups of a state file, newest first.
+func listBackups(stateFilePath string) ([]Backup, error) {
+	entries, err := os.ReadDir(backupDir(stateFilePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var backups []Backup
+	for _, e := range entries {
+		ts, ok := strings.CutPrefix(e.Name(), "state-")
+		if !ok || !strings.HasSuffix(ts, ".json") {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(ts, ".json"))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Name: e.Name(), Time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// Backups returns the backups of a saved state, newest first.
+func Backups(s State) ([]Backup, error) {
+	ss, ok := s.(*state)
+	if !ok || ss.filePath == "" {
+		return nil, nil
+	}
+	return listBackups(ss.filePath)
+}
+
+// DescribeBackup summarises the contents of a backup, for choosing which to restore.
+func DescribeBackup(s State, name string) (string, error) {
+	b, err := readBackup(s, name)
+	if err != nil {
+		return "", err
+	}
+	return b.describe(), nil
+}
+
+// RestoreBackup replaces the state's agent, assignments and surveys with those from a backup. The
+// current state is backed up first, so a restore can itself be undone.
+func RestoreBackup(s State, name string) error {
+	b, err := readBackup(s, name)
+	if err != nil {
+		return err
+	}
+	return s.Update(func(ms MutableState) error {
+		ss := ms.(lockedState).state
+		token := ss.Token
+		ss.restore(b)
+		if ss.Token == "" {
+			// Saved while the token was kept by a secret provider.
+			ss.Token = token
+		}
+		// Always back up the state being replaced.
+		return ss.backup(true)
+	})
+}
+
+func readBackup(s State, name string) (*state, error) {
+	ss, ok := s.(*state)
+	if !ok || ss.filePath == "" || name != filepath.Base(name) {
+		return nil, fmt.Errorf("no backup %s", name)
+	}
+	path := filepath.Join(backupDir(ss.filePath), name)
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeState(path, bs)
+}
+
+// decodeState decodes state file contents, upgrading older schema versions.
+func decodeState(path string, bs []byte) (*state, error) {
+	migrated, _, err := migrate(path, bs)
+	if err != nil {
+		return nil, err
+	}
+	s := &state{}
+	if err := json.Unmarshal(migrated, s); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// restore copies the saved fields of another state.
+func (s *state) restore(other *state) {
+	s.SchemaVersion = CurrentSchemaVersion
+	s.Symbol = other.Symbol
+	s.Faction = other.Faction
+	s.Email = other.Email
+	s.Token = other.Token
+	s.ResetDate = other.ResetDate
+	s.ShipAssignments = other.ShipAssignments
+	s.ContractAssignments = other.ContractAssignments
+	s.WaypointSurveys = other.WaypointSurveys
+	s.ShipBehaviors = other.ShipBehaviors
+	s.BehaviorConfigs = other.BehaviorConfigs
+	if s.ShipAssignments == nil {
+		s.ShipAssignments = map[string]string{}
+	}
+	if s.ContractAssignments == nil {
+		s.ContractAssignments = map[string][]string{}
+	}
+}
+
+func (s *state) describe() string {
+	surveys := 0
+	for _, ss := range s.WaypointSurveys {
+		surveys += len(ss)
+	}
+	return fmt.Sprintf("agent %s, %d contracts with %d assigned ships, %d surveys", s.Symbol, len(s.ContractAssignments), len(s.ShipAssignments), surveys)
+}
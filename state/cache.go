@@ -0,0 +1,180 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+// Default lifetimes of cache entries. Systems and waypoints rarely change, except when a waypoint
+// is charted, which is handled by refreshing waypoints which ships observe.
+const (
+	DefaultSystemTTL   = 24 * time.Hour
+	DefaultWaypointTTL = 24 * time.Hour
+)
+
+// CacheOptions holds how long cache entries stay fresh. Zero means the default.
+type CacheOptions struct {
+	SystemTTL   time.Duration
+	WaypointTTL time.Duration
+}
+
+// Cache holds systems and waypoints fetched from the server, saved in the profile directory so that
+// they survive restarts. Each entry records when it was fetched, and entries older than their TTL
+// are treated as missing.
+type Cache struct {
+	mu       sync.Mutex
+	path     string
+	readOnly bool
+	opts     CacheOptions
+	contents cacheContents
+}
+
+type cacheContents struct {
+	Systems   map[string]cachedSystem
+	Waypoints map[string]cachedWaypoint
+}
+
+type cachedSystem struct {
+	// Waypoints lists the symbols of the system's waypoints, whose details are cached separately.
+	Waypoints []string
+	FetchedAt time.Time
+}
+
+type cachedWaypoint struct {
+	Waypoint  api.Waypoint
+	FetchedAt time.Time
+}
+
+// OpenCache loads a profile's cache. A missing or unreadable cache file is treated as empty. A
+// read-only cache is never written back.
+func OpenCache(profile string, readOnly bool, opts CacheOptions) (*Cache, error) {
+	dir, err := ProfileDir(profile)
+	if err != nil {
+		return nil, err
+	}
+	c := NewInMemoryCache(opts)
+	c.path = filepath.Join(dir, "cache.json")
+	c.readOnly = readOnly
+	bs, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	contents := cacheContents{}
+	if err := json.Unmarshal(bs, &contents); err == nil {
+		if contents.Systems != nil {
+			c.contents.Systems = contents.Systems
+		}
+		if contents.Waypoints != nil {
+			c.contents.Waypoints = contents.Waypoints
+		}
+	}
+	return c, nil
+}
+
+// NewInMemoryCache returns a cache which is never written to disk.
+func NewInMemoryCache(opts CacheOptions) *Cache {
+	if opts.SystemTTL == 0 {
+		opts.SystemTTL = DefaultSystemTTL
+	}
+	if opts.WaypointTTL == 0 {
+		opts.WaypointTTL = DefaultWaypointTTL
+	}
+	return &Cache{
+		opts: opts,
+		contents: cacheContents{
+			Systems:   map[string]cachedSystem{},
+			Waypoints: map[string]cachedWaypoint{},
+		},
+	}
+}
+
+// SystemWaypoints returns the waypoints of a system, if the system and all of its waypoints are
+// fresh at now.
+func (c *Cache) SystemWaypoints(system string, now time.Time) ([]api.Waypoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cs, ok := c.contents.Systems[system]
+	if !ok || now.Sub(cs.FetchedAt) >= c.opts.SystemTTL {
+		return nil, false
+	}
+	waypoints := make([]api.Waypoint, 0, len(cs.Waypoints))
+	for _, symbol := range cs.Waypoints {
+		wp, ok := c.waypoint(symbol, now)
+		if !ok {
+			return nil, false
+		}
+		waypoints = append(waypoints, wp)
+	}
+	return waypoints, true
+}
+
+// Waypoint returns a waypoint, if it is fresh at now.
+func (c *Cache) Waypoint(symbol string, now time.Time) (api.Waypoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.waypoint(symbol, now)
+}
+
+func (c *Cache) waypoint(symbol string, now time.Time) (api.Waypoint, bool) {
+	cw, ok := c.contents.Waypoints[symbol]
+	if !ok || now.Sub(cw.FetchedAt) >= c.opts.WaypointTTL {
+		return api.Waypoint{}, false
+	}
+	return cw.Waypoint, true
+}
+
+// WaypointFetchedAt returns when a waypoint was last fetched, or the zero time if it is not cached.
+func (c *Cache) WaypointFetchedAt(symbol string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.contents.Waypoints[symbol].FetchedAt
+}
+
+// PutSystemWaypoints records all of a system's waypoints, fetched at now, and saves the cache.
+func (c *Cache) PutSystemWaypoints(system string, waypoints []api.Waypoint, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	symbols := make([]string, 0, len(waypoints))
+	for _, wp := range waypoints {
+		symbols = append(symbols, wp.Symbol)
+		c.contents.Waypoints[wp.Symbol] = cachedWaypoint{Waypoint: wp, FetchedAt: now}
+	}
+	c.contents.Systems[system] = cachedSystem{Waypoints: symbols, FetchedAt: now}
+	return c.save()
+}
+
+// PutWaypoint records a single waypoint, fetched at now, and saves the cache.
+func (c *Cache) PutWaypoint(wp api.Waypoint, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.contents.Waypoints[wp.Symbol] = cachedWaypoint{Waypoint: wp, FetchedAt: now}
+	return c.save()
+}
+
+// Invalidate removes everything from the cache, so that it is fetched again when next needed.
+func (c *Cache) Invalidate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.contents.Systems = map[string]cachedSystem{}
+	c.contents.Waypoints = map[string]cachedWaypoint{}
+	return c.save()
+}
+
+func (c *Cache) save() error {
+	if c.path == "" || c.readOnly {
+		return nil
+	}
+	bs, err := json.Marshal(c.contents)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, bs, 0600)
+}
@@ -0,0 +1,192 @@
+package state
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Export writes a profile's files (state, token, cache, backups and archived agents) to w as a
+// gzipped tar archive, which Import can restore on another machine. The archive includes the agent
+// token unless it is kept in an environment variable.
+func Export(profile string, w io.Writer) error {
+	dir, err := profileDir(profile)
+	if err != nil {
+		return err
+	}
+	if ok, err := ProfileExists(profile); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("profile %s does not exist", profile)
+	}
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, pattern := range append(profileFiles, "archive") {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || !d.Type().IsRegular() {
+					return err
+				}
+				rel, err := filepath.Rel(dir, path)
+				if err != nil {
+					return err
+				}
+				return addToTar(tw, path, filepath.ToSlash(rel))
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addToTar(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ImportPlan is an archive read by PlanImport, ready to be written to a profile.
+type ImportPlan struct {
+	profile string
+	dir     string
+	files   map[string][]byte
+	// Changes describes what importing would create and overwrite.
+	Changes []string
+}
+
+// maxImportFileSize guards against archives which would fill the disk.
+const maxImportFileSize = 256 << 20
+
+// PlanImport reads an archive written by Export, and works out what importing it into a profile
+// would change, without changing anything.
+func PlanImport(profile string, r io.Reader) (*ImportPlan, error) {
+	dir, err := profileDir(profile)
+	if err != nil {
+		return nil, err
+	}
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	plan := &ImportPlan{profile: profile, dir: dir, files: map[string][]byte{}}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !filepath.IsLocal(hdr.Name) || hdr.Size > maxImportFileSize {
+			return nil, fmt.Errorf("archive contains unexpected file %s", hdr.Name)
+		}
+		bs, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		plan.files[filepath.FromSlash(hdr.Name)] = bs
+	}
+	stateBytes, ok := plan.files["state.json"]
+	if !ok {
+		return nil, errors.New("archive does not contain a state file")
+	}
+	imported, err := decodeState("state.json", stateBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range plan.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		existing, err := os.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			plan.Changes = append(plan.Changes, "create "+name)
+		case err != nil:
+			return nil, err
+		case string(existing) == string(plan.files[name]):
+			// Unchanged
+		case filepath.Dir(name) == "backups":
+			plan.Changes = append(plan.Changes, "keep the existing "+name)
+		case name == "state.json":
+			change := "overwrite state.json: " + imported.describe()
+			if current, err := decodeState(path, existing); err == nil {
+				change += ", replacing " + current.describe()
+			}
+			plan.Changes = append(plan.Changes, change)
+		default:
+			plan.Changes = append(plan.Changes, "overwrite "+name)
+		}
+	}
+	return plan, nil
+}
+
+// Apply writes the imported files to the profile, after backing up its current state. Existing
+// backups are kept. It fails if another instance is using the profile.
+func (p *ImportPlan) Apply() error {
+	dir, err := ProfileDir(p.profile)
+	if err != nil {
+		return err
+	}
+	stateFilePath := filepath.Join(dir, "state.json")
+	lockFile, err := lock(stateFilePath)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	current := &state{filePath: stateFilePath}
+	if err := current.backup(true); err != nil {
+		return fmt.Errorf("backing up current state: %w", err)
+	}
+	for name, bs := range p.files {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil && filepath.Dir(name) == "backups" {
+			// Backups are never overwritten, including the one just made.
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, bs, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrReadOnly is returned when updating state which was opened read-only.
+var ErrReadOnly = errors.New("state was opened read-only")
+
+// LockedError is returned when another process holds the lock on the state file.
+type LockedError struct {
+	Path string
+	// Owner describes the process holding the lock, if known.
+	Owner string
+}
+
+func (e *LockedError) Error() string {
+	msg := fmt.Sprintf("state %s is in use by another instance", e.Path)
+	if e.Owner != "" {
+		msg += " (" + e.Owner + ")"
+	}
+	return msg + "; stop it first, or open the state read-only"
+}
+
+// writeFileAtomic replaces the file at path with data, such that after a crash the file has either
+// its old contents or its new contents. The data is written to a temporary file in the same
+// directory, flushed to disk and then renamed over the original.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	ok := false
+	defer func() {
+		if !ok {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	ok = true
+	// Make the rename itself durable. Not all platforms support syncing directories, so errors are
+	// ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package state
+
+import "os"
+
+// lock is a no-op on platforms without flock; running two instances at once is not detected.
+func lock(statePath string) (*os.File, error) {
+	return nil, nil
+}
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentSchemaVersion is the version of the state file written by this build. Files written before
+// versioning was introduced have no SchemaVersion field, and are version 0.
+const CurrentSchemaVersion = 3
+
+// migrations[n] upgrades a state file from version n to version n+1. Migrations operate on the
+// decoded JSON rather than the state struct, so that they keep working as the struct changes.
+var migrations = map[int]func(raw map[string]any) error{
+	0: migrateV0,
+	1: migrateV1,
+	2: migrateV2,
+}
+
+// NewerSchemaError is returned when the state file was written by a newer build.
+type NewerSchemaError struct {
+	Path    string
+	Version int
+}
+
+func (e *NewerSchemaError) Error() string {
+	return fmt.Sprintf("state %s has schema version %d, but this build only supports up to version %d; upgrade to a newer build", e.Path, e.Version, CurrentSchemaVersion)
+}
+
+// migrateV0 adds the assignment maps, which were missing from files written before any ship had
+// been assigned.
+func migrateV0(raw map[string]any) error {
+	for _, field := range []string{"ShipAssignments", "ContractAssignments"} {
+		if raw[field] == nil {
+			raw[field] = map[string]any{}
+		}
+	}
+	return nil
+}
+
+// migrateV1 adds the saved surveys.
+func migrateV1(raw map[string]any) error {
+	if raw["WaypointSurveys"] == nil {
+		raw["WaypointSurveys"] = map[string]any{}
+	}
+	return nil
+}
+
+// migrateV2 adds the ships' chosen behaviors and the behaviors' configuration.
+func migrateV2(raw map[string]any) error {
+	for _, field := range []string{"ShipBehaviors", "BehaviorConfigs"} {
+		if raw[field] == nil {
+			raw[field] = map[string]any{}
+		}
+	}
+	return nil
+}
+
+func schemaVersion(raw map[string]any) (int, error) {
+	v, ok := raw["SchemaVersion"]
+	if !ok {
+		return 0, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f != float64(int(f)) || f < 0 {
+		return 0, fmt.Errorf("invalid SchemaVersion %v", v)
+	}
+	return int(f), nil
+}
+
+// migrate upgrades the state file contents to the current schema version, returning the upgraded
+// contents and the version they were upgraded from.
+func migrate(path string, bs []byte) ([]byte, int, error) {
+	raw := map[string]any{}
+	if err := json.Unmarshal(bs, &raw); err != nil {
+		return nil, 0, err
+	}
+	from, err := schemaVersion(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if from > CurrentSchemaVersion {
+		return nil, from, &NewerSchemaError{Path: path, Version: from}
+	}
+	if from == CurrentSchemaVersion {
+		return bs, from, nil
+	}
+	for v := from; v < CurrentSchemaVersion; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, from, fmt.Errorf("no migration from state schema version %d", v)
+		}
+		if err := m(raw); err != nil {
+			return nil, from, fmt.Errorf("migrating %s from schema version %d: %w", path, v, err)
+		}
+		raw["SchemaVersion"] = v + 1
+	}
+	migrated, err := json.Marshal(raw)
+	return migrated, from, err
+}
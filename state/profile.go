@@ -0,0 +1,122 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/adrg/xdg"
+)
+
+// DefaultProfile is the profile used when none is chosen. Its state lives directly in the
+// spacetraders config directory, where it was kept before profiles existed; other profiles live in
+// subdirectories of profiles/.
+const DefaultProfile = "default"
+
+// ProfileEnv is the environment variable which chooses a profile when none is given explicitly.
+const ProfileEnv = "SPACETRADERS_PROFILE"
+
+var profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,31}$`)
+
+// ValidateProfileName returns an error if name cannot be used as a profile name.
+func ValidateProfileName(name string) error {
+	if !profileNameRegexp.MatchString(name) {
+		return errors.New("Profile name must be up to 32 letters, digits, '-' or '_', starting with a letter or digit")
+	}
+	return nil
+}
+
+func profileDir(name string) (string, error) {
+	if err := ValidateProfileName(name); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(xdg.ConfigHome, "spacetraders")
+	if name != DefaultProfile {
+		dir = filepath.Join(dir, "profiles", name)
+	}
+	return dir, nil
+}
+
+// ProfileDir returns the directory holding a profile's state and caches, creating it if necessary.
+func ProfileDir(name string) (string, error) {
+	dir, err := profileDir(name)
+	if err != nil {
+		return "", err
+	}
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+func stateFilePath(profile string) (string, error) {
+	dir, err := profileDir(profile)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// ProfileExists reports whether a profile has a saved agent.
+func ProfileExists(name string) (bool, error) {
+	path, err := stateFilePath(name)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Profiles lists the profiles with a saved agent, in name order.
+func Profiles() ([]string, error) {
+	var profiles []string
+	if ok, err := ProfileExists(DefaultProfile); err != nil {
+		return nil, err
+	} else if ok {
+		profiles = append(profiles, DefaultProfile)
+	}
+	entries, err := os.ReadDir(filepath.Join(xdg.ConfigHome, "spacetraders", "profiles"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == DefaultProfile || ValidateProfileName(e.Name()) != nil {
+			continue
+		}
+		if ok, err := ProfileExists(e.Name()); err != nil {
+			return nil, err
+		} else if ok {
+			profiles = append(profiles, e.Name())
+		}
+	}
+	sort.Strings(profiles)
+	return profiles, nil
+}
+
+// DeleteProfile removes a profile's saved agent, token and caches. The default profile cannot be
+// deleted, nor can a profile which another instance is using.
+func DeleteProfile(name string) error {
+	if name == DefaultProfile {
+		return fmt.Errorf("the %s profile cannot be deleted", DefaultProfile)
+	}
+	if ok, err := ProfileExists(name); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("profile %s does not exist", name)
+	}
+	path, err := stateFilePath(name)
+	if err != nil {
+		return err
+	}
+	lockFile, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	return os.RemoveAll(filepath.Dir(path))
+}
@@ -0,0 +1,129 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+)
+
+// profileFiles are the files and directories in a profile directory which belong to its agent, as
+// glob patterns. They are moved into an archive when the agent is re-registered after a server
+// reset, and exported along with the archive directory.
+var profileFiles = []string{
+	"state.json",
+	"state.json.v*.bak",
+	"cache.json",
+	"token",
+	"token.enc",
+	"backups",
+	"journal.jsonl",
+}
+
+// ServerResetDate returns the date of the most recent server reset, as reported by the server.
+func ServerResetDate(ctx context.Context, client *api.APIClient) (string, error) {
+	resp, _, err := client.DefaultApi.GetStatus(ctx).Execute()
+	if err != nil {
+		return "", err
+	}
+	return resp.ResetDate, nil
+}
+
+// Reregister archives a state whose agent was lost in a server reset, and registers the agent again
+// with the same symbol, faction and email. The archive is a folder named after the old reset date
+// in the profile's archive directory. The agent is registered before anything is archived, and the
+// archived files are moved back if the new state can't be saved, so that a failure leaves the
+// profile as it was. The returned state takes over the old state's lock.
+func Reregister(ctx context.Context, client *api.APIClient, old State) (State, error) {
+	s, ok := old.(*state)
+	if !ok || s.filePath == "" {
+		return nil, fmt.Errorf("only saved state can be re-registered")
+	}
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	if s.secrets != nil && !s.secrets.Writable() {
+		return nil, fmt.Errorf("cannot re-register %s: its new token cannot be saved to %s", s.Symbol, s.secrets)
+	}
+	ns, err := registerAgent(ctx, client, s.filePath, s.Symbol, s.Faction, s.Email, s.secrets, s.clock)
+	if err != nil {
+		return nil, fmt.Errorf("re-registering %s: %w", s.Symbol, err)
+	}
+	dir := filepath.Dir(s.filePath)
+	archiveDir, err := archive(dir, s.ResetDate, s.now())
+	if err != nil {
+		// Include the token, since otherwise the new agent would be lost.
+		return nil, fmt.Errorf("archiving state after re-registering %s (%s): %w", s.Symbol, ns.Token, err)
+	}
+	fmt.Printf("Archived the previous agent's state to %s\n", archiveDir)
+	if err := ns.saveRegistered(); err != nil {
+		err = fmt.Errorf("saving re-registered %s (%s): %w", s.Symbol, ns.Token, err)
+		if rerr := unarchive(dir, archiveDir); rerr != nil {
+			return nil, fmt.Errorf("%w; restoring the previous agent's state from %s: %v", err, archiveDir, rerr)
+		}
+		return nil, err
+	}
+	ns.lockFile = s.lockFile
+	ns.backups = s.backups
+	s.lockFile = nil
+	return ns, nil
+}
+
+// archive moves the agent's files in a profile directory to a new folder in its archive directory,
+// returning the folder. If a file can't be moved, those already moved are moved back.
+func archive(dir, resetDate string, now time.Time) (string, error) {
+	name := resetDate
+	if name == "" {
+		name = "before-" + now.Format("2006-01-02")
+	}
+	base := filepath.Join(dir, "archive", name)
+	archiveDir := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(archiveDir); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		archiveDir = fmt.Sprintf("%s-%d", base, i)
+	}
+	if err := os.MkdirAll(archiveDir, 0700); err != nil {
+		return "", err
+	}
+	for _, pattern := range profileFiles {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return "", err
+		}
+		for _, path := range matches {
+			if err := os.Rename(path, filepath.Join(archiveDir, filepath.Base(path))); err != nil {
+				if rerr := unarchive(dir, archiveDir); rerr != nil {
+					return "", fmt.Errorf("%w; restoring files from %s: %v", err, archiveDir, rerr)
+				}
+				return "", err
+			}
+		}
+	}
+	return archiveDir, nil
+}
+
+// unarchive moves the files in an archive folder back to the profile directory, replacing any
+// written since, and removes the folder.
+func unarchive(dir, archiveDir string) error {
+	entries, err := os.ReadDir(archiveDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(archiveDir, e.Name()), path); err != nil {
+			return err
+		}
+	}
+	return os.Remove(archiveDir)
+}
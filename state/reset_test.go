@@ -0,0 +1,84 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/fakeserver"
+)
+
+// savedState writes the state of an agent from before a server reset to a new profile directory.
+func savedState(t *testing.T) *state {
+	t.Helper()
+	s := &state{
+		filePath:      filepath.Join(t.TempDir(), "state.json"),
+		backups:       BackupOptions{Keep: -1},
+		SchemaVersion: CurrentSchemaVersion,
+		Symbol:        "TESTER",
+		Faction:       "COSMIC",
+		Token:         "old-token",
+		ResetDate:     "2023-05-20",
+	}
+	if err := s.write(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReregisterArchivesState(t *testing.T) {
+	srv := fakeserver.Start()
+	defer srv.Close()
+	s := savedState(t)
+
+	ns, err := Reregister(context.Background(), srv.Client(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.GetToken() == "old-token" {
+		t.Errorf("re-registered state kept the old token")
+	}
+	archived, err := decodeStateFile(filepath.Join(filepath.Dir(s.filePath), "archive", "2023-05-20", "state.json"))
+	if err != nil {
+		t.Fatalf("reading archived state: %v", err)
+	}
+	if archived.Token != "old-token" {
+		t.Errorf("archived token = %q, want old-token", archived.Token)
+	}
+}
+
+// TestReregisterFailureKeepsState checks that the profile is left as it was when the agent can't be
+// registered again.
+func TestReregisterFailureKeepsState(t *testing.T) {
+	srv := fakeserver.Start()
+	defer srv.Close()
+	// Someone else claims the symbol after the reset.
+	if _, _, err := srv.Client().DefaultApi.Register(context.Background()).RegisterRequest(api.RegisterRequest{Symbol: "TESTER", Faction: "COSMIC"}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	s := savedState(t)
+
+	if _, err := Reregister(context.Background(), srv.Client(), s); err == nil {
+		t.Fatalf("re-registering a claimed symbol succeeded")
+	}
+	saved, err := decodeStateFile(s.filePath)
+	if err != nil {
+		t.Fatalf("reading state after failure: %v", err)
+	}
+	if saved.Token != "old-token" {
+		t.Errorf("token after failure = %q, want old-token", saved.Token)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(s.filePath), "archive")); !os.IsNotExist(err) {
+		t.Errorf("archive created despite failure: %v", err)
+	}
+}
+
+func decodeStateFile(path string) (*state, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeState(path, bs)
+}
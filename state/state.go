@@ -9,11 +9,13 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-
-	"github.com/adrg/xdg"
+	"sync"
+	"time"
 
 	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/clock"
 	"fivebit.co.uk/spacetraders/prompt"
+	"fivebit.co.uk/spacetraders/secret"
 )
 
 var (
@@ -27,21 +29,53 @@ var (
 )
 
 type state struct {
+	// mu guards the fields below it; Update holds it while the update function runs and the file is
+	// written.
+	mu       sync.Mutex
 	filePath string
-	Symbol string
-	Faction string
-	Email string
-	Token string
-	ShipAssignments map[string]string
+	readOnly bool
+	lockFile *os.File
+	// secrets holds the token instead of the state file, if set.
+	secrets secret.Provider
+	backups BackupOptions
+	// clock decides which surveys have expired and names backups; nil means the real clock.
+	clock         clock.Clock
+	SchemaVersion int
+	Symbol        string
+	Faction       string
+	Email         string
+	Token         string
+	// ResetDate is the date of the server reset the agent was registered after, if known.
+	ResetDate           string
+	ShipAssignments     map[string]string
 	ContractAssignments map[string][]string
+	// WaypointSurveys holds unexpired surveys by waypoint.
+	WaypointSurveys map[string][]api.Survey
+	// ShipBehaviors holds the behavior chosen for each ship, by name, where it isn't the default.
+	ShipBehaviors map[string]string
+	// BehaviorConfigs holds each behavior's configuration, by behavior name.
+	BehaviorConfigs map[string]json.RawMessage
 }
 
 type State interface {
 	GetToken() string
+	// GetResetDate returns the date of the server reset the agent was registered after, or "" if it
+	// is not known.
+	GetResetDate() string
 	Update(func(ms MutableState) error) error
 	AssignedContract(shipID string) string
 	AssignedShips(contractID string) []string
 	ActiveContracts() []string
+	// Assignments returns copies of the ship -> contract and contract -> ships maps.
+	Assignments() (map[string]string, map[string][]string)
+	// Surveys returns copies of the saved surveys, by waypoint.
+	Surveys() map[string][]api.Survey
+	// ShipBehavior returns the name of the behavior chosen for a ship, or "" for the default.
+	ShipBehavior(shipID string) string
+	// BehaviorConfig returns a behavior's saved configuration, or nil if there is none.
+	BehaviorConfig(name string) json.RawMessage
+	// Close releases the lock on the state file.
+	Close() error
 }
 
 type MutableState interface {
@@ -49,36 +83,143 @@ type MutableState interface {
 	AssignShip(contractID, shipID string)
 	UnassignShip(contractID, shipID string)
 	CompleteContract(contractID string)
+	// SetAssignments replaces all assignments, e.g. after correcting inconsistencies.
+	SetAssignments(ships map[string]string, contracts map[string][]string)
+	AddSurveys(waypoint string, surveys []api.Survey)
+	RemoveSurvey(waypoint, signature string)
+	SetResetDate(date string)
+	// SetShipBehavior chooses a ship's behavior by name; "" goes back to the default.
+	SetShipBehavior(shipID, name string)
+	SetBehaviorConfig(name string, config json.RawMessage)
 }
 
 func (s *state) GetToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.Token
 }
 
+func (s *state) GetResetDate() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ResetDate
+}
+
+func (s *state) SetResetDate(date string) {
+	s.ResetDate = date
+}
+
 func (s *state) Update(fn func(ms MutableState) error) error {
-	if err := fn(s); err != nil {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := fn(lockedState{s}); err != nil {
 		return err
 	}
 	return s.write()
 }
 
+// lockedState is the MutableState passed to Update functions. The lock is already held, so its
+// methods read the state directly; calling Update again from within an update would deadlock.
+type lockedState struct {
+	*state
+}
+
+func (s lockedState) GetToken() string {
+	return s.Token
+}
+
+func (s lockedState) GetResetDate() string {
+	return s.ResetDate
+}
+
+func (s lockedState) AssignedContract(shipID string) string {
+	return s.assignedContract(shipID)
+}
+
+func (s lockedState) AssignedShips(contractID string) []string {
+	return s.assignedShips(contractID)
+}
+
+func (s lockedState) ActiveContracts() []string {
+	return s.activeContracts()
+}
+
+func (s lockedState) Assignments() (map[string]string, map[string][]string) {
+	return s.assignments()
+}
+
+func (s lockedState) Surveys() map[string][]api.Survey {
+	return s.surveys()
+}
+
+func (s lockedState) ShipBehavior(shipID string) string {
+	return s.ShipBehaviors[shipID]
+}
+
+func (s lockedState) BehaviorConfig(name string) json.RawMessage {
+	return s.BehaviorConfigs[name]
+}
+
 func (s *state) write() error {
+	if s.filePath == "" {
+		return nil
+	}
+	s.pruneSurveys(s.now())
+	if err := s.backup(false); err != nil {
+		// Not worth losing the update for.
+		fmt.Printf("Backing up state failed: %v\n", err)
+	}
+	token := s.Token
+	if s.secrets != nil {
+		s.Token = ""
+	}
 	bs, err := json.Marshal(s)
+	s.Token = token
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.filePath, bs, 0600)
+	return writeFileAtomic(s.filePath, bs, 0600)
+}
+
+func (s *state) Close() error {
+	if s.lockFile == nil {
+		return nil
+	}
+	err := s.lockFile.Close()
+	s.lockFile = nil
+	return err
 }
 
 func (s *state) AssignedContract(shipID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.assignedContract(shipID)
+}
+
+func (s *state) assignedContract(shipID string) string {
 	return s.ShipAssignments[shipID]
 }
 
 func (s *state) AssignedShips(contractID string) []string {
-	return s.ContractAssignments[contractID]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.assignedShips(contractID)
+}
+
+func (s *state) assignedShips(contractID string) []string {
+	return append([]string(nil), s.ContractAssignments[contractID]...)
 }
 
 func (s *state) ActiveContracts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeContracts()
+}
+
+func (s *state) activeContracts() []string {
 	var ids []string
 	for cID := range s.ContractAssignments {
 		ids = append(ids, cID)
@@ -86,11 +227,130 @@ func (s *state) ActiveContracts() []string {
 	return ids
 }
 
+func (s *state) Assignments() (map[string]string, map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.assignments()
+}
+
+func (s *state) assignments() (map[string]string, map[string][]string) {
+	ships := map[string]string{}
+	for shipID, contractID := range s.ShipAssignments {
+		ships[shipID] = contractID
+	}
+	contracts := map[string][]string{}
+	for contractID, shipIDs := range s.ContractAssignments {
+		contracts[contractID] = append([]string(nil), shipIDs...)
+	}
+	return ships, contracts
+}
+
 func (s *state) AssignShip(contractID, shipID string) {
+	if previous, ok := s.ShipAssignments[shipID]; ok {
+		if previous == contractID {
+			return
+		}
+		s.UnassignShip(previous, shipID)
+	}
 	s.ShipAssignments[shipID] = contractID
 	s.ContractAssignments[contractID] = append(s.ContractAssignments[contractID], shipID)
 }
 
+func (s *state) SetAssignments(ships map[string]string, contracts map[string][]string) {
+	s.ShipAssignments = ships
+	s.ContractAssignments = contracts
+}
+
+func (s *state) Surveys() map[string][]api.Survey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.surveys()
+}
+
+func (s *state) surveys() map[string][]api.Survey {
+	surveys := map[string][]api.Survey{}
+	for waypoint, ss := range s.WaypointSurveys {
+		surveys[waypoint] = append([]api.Survey(nil), ss...)
+	}
+	return surveys
+}
+
+func (s *state) AddSurveys(waypoint string, surveys []api.Survey) {
+	if s.WaypointSurveys == nil {
+		s.WaypointSurveys = map[string][]api.Survey{}
+	}
+	s.WaypointSurveys[waypoint] = append(s.WaypointSurveys[waypoint], surveys...)
+}
+
+func (s *state) RemoveSurvey(waypoint, signature string) {
+	var kept []api.Survey
+	for _, survey := range s.WaypointSurveys[waypoint] {
+		if survey.Signature != signature {
+			kept = append(kept, survey)
+		}
+	}
+	if len(kept) == 0 {
+		delete(s.WaypointSurveys, waypoint)
+	} else {
+		s.WaypointSurveys[waypoint] = kept
+	}
+}
+
+func (s *state) ShipBehavior(shipID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ShipBehaviors[shipID]
+}
+
+func (s *state) SetShipBehavior(shipID, name string) {
+	if name == "" {
+		delete(s.ShipBehaviors, shipID)
+		return
+	}
+	if s.ShipBehaviors == nil {
+		s.ShipBehaviors = map[string]string{}
+	}
+	s.ShipBehaviors[shipID] = name
+}
+
+func (s *state) BehaviorConfig(name string) json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.BehaviorConfigs[name]
+}
+
+func (s *state) SetBehaviorConfig(name string, config json.RawMessage) {
+	if s.BehaviorConfigs == nil {
+		s.BehaviorConfigs = map[string]json.RawMessage{}
+	}
+	s.BehaviorConfigs[name] = config
+}
+
+// now returns the current time according to the state's clock.
+func (s *state) now() time.Time {
+	if s.clock == nil {
+		return clock.Real().Now()
+	}
+	return s.clock.Now()
+}
+
+// pruneSurveys removes surveys which have expired by now.
+func (s *state) pruneSurveys(now time.Time) {
+	for waypoint, surveys := range s.WaypointSurveys {
+		var kept []api.Survey
+		for _, survey := range surveys {
+			if now.Before(survey.Expiration) {
+				kept = append(kept, survey)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.WaypointSurveys, waypoint)
+		} else {
+			s.WaypointSurveys[waypoint] = kept
+		}
+	}
+}
+
 func (s *state) UnassignShip(contractID, shipID string) {
 	delete(s.ShipAssignments, shipID)
 	var filteredShips []string
@@ -109,43 +369,140 @@ func (s *state) CompleteContract(contractID string) {
 	delete(s.ContractAssignments, contractID)
 }
 
-func Get(ctx context.Context, client *api.APIClient) (State, error) {
-	stateFilePath, err := xdg.ConfigFile(filepath.Join("spacetraders", "state.json"))
+// Options control how state is loaded.
+type Options struct {
+	// ReadOnly opens the state without locking it; see Get.
+	ReadOnly bool
+	// Secrets holds the agent token. Nil means the token is kept in the state file.
+	Secrets secret.Provider
+	Backups BackupOptions
+	// Clock decides which saved surveys have expired, e.g. a fake clock when simulating. Nil means the
+	// real clock.
+	Clock clock.Clock
+}
+
+// Get loads a profile's state, registering a new agent if there is none. Unless opts.ReadOnly is
+// set, the state is locked so that no other instance can use it until it is closed; read-only state
+// can be opened while another instance holds the lock, but cannot be updated.
+func Get(ctx context.Context, client *api.APIClient, profile string, opts Options) (State, error) {
+	dir, err := ProfileDir(profile)
 	if err != nil {
 		return nil, err
 	}
+	stateFilePath := filepath.Join(dir, "state.json")
+	readOnly := opts.ReadOnly
 
+	var lockFile *os.File
+	if !readOnly {
+		if lockFile, err = lock(stateFilePath); err != nil {
+			return nil, err
+		}
+	}
+	s, err := load(ctx, client, stateFilePath, opts)
+	if err != nil {
+		if lockFile != nil {
+			lockFile.Close()
+		}
+		return nil, err
+	}
+	s.lockFile = lockFile
+	s.backups = opts.Backups
+	return s, nil
+}
+
+func load(ctx context.Context, client *api.APIClient, stateFilePath string, opts Options) (*state, error) {
+	readOnly := opts.ReadOnly
 	bs, err := os.ReadFile(stateFilePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return create(ctx, client, stateFilePath)
+		if os.IsNotExist(err) && !readOnly {
+			return create(ctx, client, stateFilePath, opts.Secrets, opts.Clock)
 		}
 		return nil, err
 	}
 
-	s := &state{}
-	if err := json.Unmarshal(bs, s); err != nil {
+	migrated, from, err := migrate(stateFilePath, bs)
+	if err != nil {
+		return nil, err
+	}
+	if from < CurrentSchemaVersion && !readOnly {
+		backupPath := fmt.Sprintf("%s.v%d.bak", stateFilePath, from)
+		if err := writeFileAtomic(backupPath, bs, 0600); err != nil {
+			return nil, fmt.Errorf("backing up state before migration: %w", err)
+		}
+		if err := writeFileAtomic(stateFilePath, migrated, 0600); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Upgraded state from schema version %d to %d; the old state was saved to %s\n", from, CurrentSchemaVersion, backupPath)
+	}
+
+	s := &state{readOnly: readOnly, clock: opts.Clock}
+	if err := json.Unmarshal(migrated, s); err != nil {
 		return nil, err
 	}
 
-	// TODO: validate state
+	// Assignments are checked against the server once the app has loaded its ships and contracts;
+	// see App.reconcileAssignments.
 	s.filePath = stateFilePath
-	if s.ShipAssignments == nil {
-		s.ShipAssignments = map[string]string{}
-	}
-	if s.ContractAssignments == nil {
-		s.ContractAssignments = map[string][]string{}
+	s.pruneSurveys(s.now())
+	s.secrets = opts.Secrets
+	if err := s.resolveToken(); err != nil {
+		return nil, err
 	}
 
 	return s, nil
 }
 
+// resolveToken reads the token from the secret provider, if there is one. A token left in the state
+// file, e.g. from before the provider was configured, is moved to the provider if possible.
+func (s *state) resolveToken() error {
+	if s.secrets == nil {
+		return nil
+	}
+	if s.Token != "" {
+		if s.readOnly || !s.secrets.Writable() {
+			return nil
+		}
+		if err := s.secrets.SetToken(s.Token); err != nil {
+			return fmt.Errorf("saving token to %s: %w", s.secrets, err)
+		}
+		if err := s.write(); err != nil {
+			return err
+		}
+		fmt.Printf("Moved the agent token from the state file to %s\n", s.secrets)
+		return nil
+	}
+	token, err := s.secrets.Token()
+	if err != nil {
+		return fmt.Errorf("reading token from %s: %w", s.secrets, err)
+	}
+	s.Token = token
+	return nil
+}
+
+// NewInMemory returns a state for an existing agent which is never written to disk.
+func NewInMemory(symbol, faction, token string) State {
+	return &state{
+		SchemaVersion:       CurrentSchemaVersion,
+		Symbol:              symbol,
+		Faction:             faction,
+		Token:               token,
+		ShipAssignments:     map[string]string{},
+		ContractAssignments: map[string][]string{},
+		WaypointSurveys:     map[string][]api.Survey{},
+		ShipBehaviors:       map[string]string{},
+		BehaviorConfigs:     map[string]json.RawMessage{},
+	}
+}
+
 var (
 	agentSymbolRegexp = regexp.MustCompile(`[A-Z0-9]{3,14}`)
 )
 
-func create(ctx context.Context, client *api.APIClient, stateFilePath string) (State, error) {
+func create(ctx context.Context, client *api.APIClient, stateFilePath string, secrets secret.Provider, clk clock.Clock) (*state, error) {
 	fmt.Println("No state found; creating new agent")
+	if secrets != nil && !secrets.Writable() {
+		return nil, fmt.Errorf("cannot register a new agent: its token cannot be saved to %s", secrets)
+	}
 
 	symbol, err := prompt.Prompt("Agent symbol", func(input string) error {
 		if !agentSymbolRegexp.MatchString(input) {
@@ -170,26 +527,64 @@ func create(ctx context.Context, client *api.APIClient, stateFilePath string) (S
 		return nil, err
 	}
 
-	resp, _, err := client.DefaultApi.Register(ctx).RegisterRequest(api.RegisterRequest{
-		Symbol: symbol,
+	return register(ctx, client, stateFilePath, symbol, faction, email, secrets, clk)
+}
+
+// register registers a new agent and saves its state, and its token to secrets if set.
+func register(ctx context.Context, client *api.APIClient, stateFilePath, symbol, faction, email string, secrets secret.Provider, clk clock.Clock) (*state, error) {
+	s, err := registerAgent(ctx, client, stateFilePath, symbol, faction, email, secrets, clk)
+	if err != nil {
+		return nil, err
+	}
+	return s, s.saveRegistered()
+}
+
+// registerAgent registers a new agent, returning its state without saving anything.
+func registerAgent(ctx context.Context, client *api.APIClient, stateFilePath, symbol, faction, email string, secrets secret.Provider, clk clock.Clock) (*state, error) {
+	req := api.RegisterRequest{
+		Symbol:  symbol,
 		Faction: faction,
-		Email: &email,
-	}).Execute()
+	}
+	if email != "" {
+		req.Email = &email
+	}
+	resp, _, err := client.DefaultApi.Register(ctx).RegisterRequest(req).Execute()
 	if err != nil {
 		return nil, err
 	}
 
+	// The reset date is only informational, so failing to get it is not fatal.
+	resetDate, _ := ServerResetDate(ctx, client)
+
 	s := &state{
-		filePath: stateFilePath,
-		Symbol: symbol,
-		Faction: faction,
-		Email: email,
-		Token: resp.Data.Token,
-		ShipAssignments: map[string]string{},
+		filePath:            stateFilePath,
+		secrets:             secrets,
+		clock:               clk,
+		SchemaVersion:       CurrentSchemaVersion,
+		Symbol:              symbol,
+		Faction:             faction,
+		Email:               email,
+		Token:               resp.Data.Token,
+		ResetDate:           resetDate,
+		ShipAssignments:     map[string]string{},
 		ContractAssignments: map[string][]string{},
+		WaypointSurveys:     map[string][]api.Survey{},
+		ShipBehaviors:       map[string]string{},
+		BehaviorConfigs:     map[string]json.RawMessage{},
 	}
+	return s, nil
+}
 
-	fmt.Printf("Registered %s with token %s\n", symbol, s.Token)
-
-	return s, s.write()
+// saveRegistered saves a newly registered agent's state, and its token to secrets if set.
+func (s *state) saveRegistered() error {
+	if s.secrets != nil {
+		if err := s.secrets.SetToken(s.Token); err != nil {
+			// Include the token, since otherwise the new agent would be lost.
+			return fmt.Errorf("saving token for %s (%s) to %s: %w", s.Symbol, s.Token, s.secrets, err)
+		}
+		fmt.Printf("Registered %s; token %s saved to %s\n", s.Symbol, secret.Mask(s.Token), s.secrets)
+	} else {
+		fmt.Printf("Registered %s; token %s saved to %s\n", s.Symbol, secret.Mask(s.Token), s.filePath)
+	}
+	return s.write()
 }
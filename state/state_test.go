@@ -0,0 +1,47 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fivebit.co.uk/spacetraders/api"
+	"fivebit.co.uk/spacetraders/clock"
+)
+
+// TestLoadPrunesSurveysByClock checks that surveys expire by the injected clock rather than the
+// wall clock.
+func TestLoadPrunesSurveysByClock(t *testing.T) {
+	start := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	saved := map[string]any{
+		"SchemaVersion": CurrentSchemaVersion,
+		"Symbol":        "TESTER",
+		"Token":         "token",
+		"WaypointSurveys": map[string][]api.Survey{
+			"X1-TS01-20002B": {
+				{Signature: "EXPIRED", Expiration: start.Add(-time.Minute)},
+				{Signature: "FRESH", Expiration: start.Add(time.Minute)},
+			},
+		},
+	}
+	bs, err := json.Marshal(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, bs, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := load(context.Background(), nil, path, Options{ReadOnly: true, Clock: clock.NewFake(start)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	surveys := s.Surveys()["X1-TS01-20002B"]
+	if len(surveys) != 1 || surveys[0].Signature != "FRESH" {
+		t.Errorf("surveys after load = %+v, want only FRESH", surveys)
+	}
+}